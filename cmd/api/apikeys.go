@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// Make a key for another service. The plaintext key is only in this
+// response, after that we only have its hash:
+//
+//	{"name": "newsletter", "user_id": 7, "scopes": ["comments:read"], "rps": 20, "burst": 40}
+//
+// rps and burst default to the limits every other client gets
+func (a *applicationDependencies) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name   string   `json:"name"`
+		UserID int64    `json:"user_id"`
+		Scopes []string `json:"scopes"`
+		RPS    *float64 `json:"rps"`
+		Burst  *int     `json:"burst"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:   incomingData.Name,
+		UserID: incomingData.UserID,
+		Scopes: incomingData.Scopes,
+		RPS:    a.config.limiter.rps,
+		Burst:  a.config.limiter.burst,
+	}
+	if incomingData.RPS != nil {
+		key.RPS = *incomingData.RPS
+	}
+	if incomingData.Burst != nil {
+		key.Burst = *incomingData.Burst
+	}
+
+	v := validator.New()
+	data.ValidateAPIKey(v, key)
+	if key.UserID > 0 {
+		_, err = a.userModel.Get(key.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("user_id", "must refer to an existing user")
+			default:
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.apiKeyModel.Insert(key)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/api-keys/%d", key.ID))
+	data := envelope{
+		"api_key": key,
+	}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// List every key, revoked ones included. The keys themselves are not
+// in the list since we don't have them
+func (a *applicationDependencies) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.apiKeyModel.GetAll()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"api_keys": keys,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke a key. The service using it is turned away from its next request
+func (a *applicationDependencies) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.apiKeyModel.Revoke(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "API key successfully revoked",
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,17 +2,50 @@ package main
 
 import (
 	//"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
 	"github.com/tchenbz/comments/internal/data"
 	"github.com/tchenbz/comments/internal/validator"
 )
 
+// Run the content policy on a new or edited comment that is otherwise
+// valid. Rejections end up in v. A comment that a rule flagged is held
+// back for a moderator, but only if it is live: pending and rejected
+// comments are already out of sight. It reports whether it held it back
+func (a *applicationDependencies) applyContentPolicy(ctx context.Context, comment *data.Comment, v *validator.Validator) (bool, error) {
+	flagged, err := a.contentPolicy.Apply(ctx, comment, v)
+	if err != nil {
+		return false, err
+	}
+	// masking can make the content longer (a link becomes "[link]")
+	// so the masked content has to pass the same checks again
+	if v.IsEmpty() {
+		data.ValidateComment(v, comment)
+	}
+
+	if len(flagged) > 0 && v.IsEmpty() && comment.Status == data.CommentStatusApproved {
+		comment.Status = data.CommentStatusFlagged
+		a.logger.Info("comment flagged by the content policy", "user_id", comment.Author.ID, "rules", flagged)
+		return true, nil
+	}
+
+	return false, nil
+}
+
 func (a *applicationDependencies)createCommentHandler(w http.ResponseWriter, r *http.Request) {
 	var incomingData struct {
 		Content string `json:"content"`
-		Author string `json:"author"`
+		ParentID *int64 `json:"parent_id"`
+		TargetType string `json:"target_type"`
+		TargetID string `json:"target_id"`
 	}
 
 	//err := json.NewDecoder(r.Body).Decode(&incomingData)
@@ -26,30 +59,67 @@ func (a *applicationDependencies)createCommentHandler(w http.ResponseWriter, r *
 	// Copy the values from incomingData to a new Comment struct
 	// At this point in our code the JSON is well-formed JSON so now
 	// we will validate it using the Validator which expects a Comment
+	// The author is whoever is logged in, not something the client gets to choose
+	user := a.contextGetUser(r)
 	comment := &data.Comment {
     Content: incomingData.Content,
-    Author: incomingData.Author,
+    Author: data.CommentAuthor{ID: user.ID, Name: user.Name},
+    ParentID: incomingData.ParentID,
+    TargetType: incomingData.TargetType,
+    TargetID: incomingData.TargetID,
+    // depending on -comments-default-status the comment either goes
+    // live right away or waits in the moderation queue
+    Status: a.config.comments.defaultStatus,
 	}
 	// Initialize a Validator instance
   	v := validator.New()
 	// Do the validation
 	data.ValidateComment(v, comment)
+	// A reply must point to a comment that actually exists, and is
+	// about the same thing as that comment so the target can be left out
+	if comment.ParentID != nil {
+		parent, err := a.getVisibleComment(r, *comment.ParentID)
+		if err != nil {
+			switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					v.AddError("parent_id", "must refer to an existing comment")
+				default:
+					a.serverErrorResponse(w, r, err)
+					return
+			}
+		} else {
+			v.Check(comment.TargetType == "" || comment.TargetType == parent.TargetType, "target_type", "must be the same as the parent comment's")
+			v.Check(comment.TargetID == "" || comment.TargetID == parent.TargetID, "target_id", "must be the same as the parent comment's")
+			comment.TargetType = parent.TargetType
+			comment.TargetID = parent.TargetID
+		}
+	} else {
+		data.ValidateTarget(v, comment.TargetType, comment.TargetID, a.config.targets.types)
+	}
+	// The content policy only looks at comments that are otherwise fine
+	if v.IsEmpty() {
+		_, err = a.applyContentPolicy(r.Context(), comment, v)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	if !v.IsEmpty() {
     a.failedValidationResponse(w, r, v.Errors)  // implemented later
     return
 }
 
 	// Add the comment to the database table
-	err = a.commentModel.Insert(comment)
+	err = a.commentModel.Insert(r.Context(), comment)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 
-	fmt.Fprintf(w, "%+v\n", incomingData)      // delete this
 	// Set a Location header. The path to the newly created comment
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/comments/%d", comment.ID))
+	headers.Set("ETag", commentETag(comment))
 
 	  // Send a JSON response with 201 (new resource created) status code
 	  data := envelope{
@@ -60,9 +130,6 @@ func (a *applicationDependencies)createCommentHandler(w http.ResponseWriter, r *
 	  a.serverErrorResponse(w, r, err)
 	  return
   }
-
-
-	fmt.Fprintf(w, "%+v\n", incomingData)
 }
 
 func (a *applicationDependencies)displayCommentHandler(w http.ResponseWriter, r *http.Request) {
@@ -75,8 +142,16 @@ func (a *applicationDependencies)displayCommentHandler(w http.ResponseWriter, r
 		return 
 	}
 
+	// ?format=html adds the content rendered from Markdown
+	v := validator.New()
+	format := a.readFormatParameter(r.URL.Query(), v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call Get() to retrieve the comment with the specified id
-	comment, err := a.commentModel.Get(id)
+	comment, err := a.getVisibleComment(r, id)
 	if err != nil {
 		switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -86,12 +161,22 @@ func (a *applicationDependencies)displayCommentHandler(w http.ResponseWriter, r
 		}
 		return 
 	}
+	if format == "html" {
+		err = a.renderComments([]*data.Comment{comment})
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-	// display the comment
+	// display the comment. The ETag only changes when the comment
+	// does, so a client that polls gets a 304 most of the time
+	headers := make(http.Header)
+	headers.Set("ETag", commentETag(comment))
     data := envelope {
 		"comment": comment,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeCachedJSON(w, r, data, headers, comment.UpdatedAt)
 	if err != nil {
 	a.serverErrorResponse(w, r, err)
 	return 
@@ -107,7 +192,7 @@ func (a *applicationDependencies)updateCommentHandler(w http.ResponseWriter, r *
 	return 
 	}
 	// Call Get() to retrieve the comment with the specified id
-	comment, err := a.commentModel.Get(id)
+	comment, err := a.getVisibleComment(r, id)
 	if err != nil {
 		switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -117,13 +202,33 @@ func (a *applicationDependencies)updateCommentHandler(w http.ResponseWriter, r *
 		}
 		return 
 	}
+	// Only the author of a comment or a moderator is allowed to change it
+	allowed, err := a.canModifyComment(r, comment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		a.notPermittedResponse(w, r)
+		return
+	}
+	// If the client told us which version it is editing and that is no
+	// longer the current version there is no point attempting the update
+	expectedVersions, found, err := a.readExpectedVersion(r, comment.ID)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	if found && !slices.Contains(expectedVersions, comment.Version) {
+		a.editConflictResponse(w, r)
+		return
+	}
 	// Use our temporary incomingData struct to hold the data
 	// Note: I have changed the types to pointer to differentiate
 	// between the client leaving a field empty intentionally
 	// and the field not needing to be updated
 	var incomingData struct {
 			Content  *string  `json:"content"`
-			Author   *string  `json:"author"`
 		}  
 	// perform the decoding
 	err = a.readJSON(w, r, &incomingData)
@@ -136,28 +241,41 @@ func (a *applicationDependencies)updateCommentHandler(w http.ResponseWriter, r *
 	if incomingData.Content != nil {
 		comment.Content = *incomingData.Content
 	}
-	// if incomingData.Author is nil, no update was provided
-	if incomingData.Author != nil {
-		comment.Author = *incomingData.Author
-	}
 
 	// Before we write the updates to the DB let's validate
 	v := validator.New()
 	data.ValidateComment(v, comment)
+	flag := false
+	if v.IsEmpty() {
+		flag, err = a.applyContentPolicy(r.Context(), comment, v)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)  
 		return
 	}
-	// perform the update
-    err = a.commentModel.Update(comment)
+	// perform the update. The status is only written when the policy
+	// flagged this edit, a moderator may have changed it since we read it
+    err = a.commentModel.Update(r.Context(), comment, flag)
     if err != nil {
-       a.serverErrorResponse(w, r, err)
+       switch {
+           case errors.Is(err, data.ErrEditConflict):
+              a.editConflictResponse(w, r)
+           default:
+              a.serverErrorResponse(w, r, err)
+       }
        return 
    }
+   // send back the new ETag so the client can make its next edit
+   headers := make(http.Header)
+   headers.Set("ETag", commentETag(comment))
    data := envelope {
                 "comment": comment,
           }
-   err = a.writeJSON(w, http.StatusOK, data, nil)
+   err = a.writeJSON(w, http.StatusOK, data, headers)
    if err != nil {
        a.serverErrorResponse(w, r, err)
        return 
@@ -171,7 +289,27 @@ func (a *applicationDependencies)deleteCommentHandler(w http.ResponseWriter, r *
 		a.notFoundResponse(w, r)
 		return 
 	}
-    err = a.commentModel.Delete(id)
+	// We need the comment to find out who owns it
+	comment, err := a.getVisibleComment(r, id)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	allowed, err := a.canModifyComment(r, comment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		a.notPermittedResponse(w, r)
+		return
+	}
+    err = a.commentModel.Delete(r.Context(), id)
 
    if err != nil {
        switch {
@@ -198,19 +336,12 @@ func (a *applicationDependencies)listCommentsHandler(w http.ResponseWriter, r *h
 	// Create a struct to hold the query parameters
 	// Later on we will add fields for pagination and sorting (filters)
 	var queryParametersData struct {
-		Content string
-		Author  string
+		data.CommentQuery
 		data.Filters
 	}
 	// get the query parameters from the URL
 	queryParameters := r.URL.Query()
 
-		comments, metadata, err := a.commentModel.GetAll(queryParametersData.Content, queryParametersData.Author, queryParametersData.Filters)
-	if err != nil {
-		a.serverErrorResponse(w, r, err)
-		return
-	}
-
 	// Load the query parameters into our struct
     queryParametersData.Content = a.getSingleQueryParameter(
 		queryParameters,
@@ -225,10 +356,51 @@ func (a *applicationDependencies)listCommentsHandler(w http.ResponseWriter, r *h
 	// Create a new validator instance
 	v := validator.New()
 
+	format := a.readFormatParameter(queryParameters, v)
+	// /v1/targets/:type/:id/comments has the target in the path,
+	// /v1/comments can be narrowed down with ?target_type=&target_id=
+	params := httprouter.ParamsFromContext(r.Context())
+	if params.ByName("type") != "" {
+		queryParametersData.TargetType = params.ByName("type")
+		queryParametersData.TargetID = params.ByName("id")
+	} else {
+		queryParametersData.TargetType = a.getSingleQueryParameter(queryParameters, "target_type", "")
+		queryParametersData.TargetID = a.getSingleQueryParameter(queryParameters, "target_id", "")
+	}
+	if queryParametersData.TargetType != "" || queryParametersData.TargetID != "" {
+		data.ValidateTarget(v, queryParametersData.TargetType, queryParametersData.TargetID, a.config.targets.types)
+	}
+	queryParametersData.IncludeDeleted = a.getSingleBooleanParameter(queryParameters, "include_deleted", false, v)
+	queryParametersData.Status = a.getSingleQueryParameter(queryParameters, "status", "")
+	if queryParametersData.Status != "" {
+		v.Check(validator.PermittedValue(queryParametersData.Status, data.CommentStatuses...), "status", "invalid status value")
+	}
 	queryParametersData.Filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
 	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
 	queryParametersData.Filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
-	queryParametersData.Filters.SortSafeList = []string {"id", "author", "-id", "-author"}
+	queryParametersData.Filters.SortSafeList = []string {"id", "author", "score", "-id", "-author", "-score"}
+
+	// Sending ?cursor= switches to keyset pagination. An empty cursor
+	// asks for the first page, after that the client sends back the
+	// next_cursor or prev_cursor from the metadata
+	if queryParameters.Has("cursor") {
+		cursor := &data.Cursor{Sort: queryParametersData.Filters.Sort}
+		token := queryParameters.Get("cursor")
+		if token != "" {
+			decoded, err := data.DecodeCursor(token, []byte(a.config.cursor.secret))
+			if err != nil {
+				v.AddError("cursor", "is invalid")
+			} else {
+				cursor = &decoded
+				// the cursor knows its sort so clients don't have to repeat it
+				if !queryParameters.Has("sort") {
+					queryParametersData.Filters.Sort = cursor.Sort
+				}
+			}
+		}
+		v.Check(!queryParameters.Has("page"), "page", "cannot be used together with cursor")
+		queryParametersData.Filters.Cursor = cursor
+	}
 
 	// Check if our filters are valid
 	data.ValidateFilters(v, queryParametersData.Filters)
@@ -237,12 +409,301 @@ func (a *applicationDependencies)listCommentsHandler(w http.ResponseWriter, r *h
 	return
 	}
 
+	// Deleted comments, and comments that are not approved, are
+	// only for moderators. Everybody else only sees approved ones
+	moderator, err := a.userHasPermission(r, data.PermissionCommentsModerate)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !moderator {
+		if queryParametersData.IncludeDeleted || (queryParametersData.Status != "" && queryParametersData.Status != data.CommentStatusApproved) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		queryParametersData.Status = data.CommentStatusApproved
+	}
+
+	comments, metadata, err := a.commentModel.GetAll(r.Context(), queryParametersData.CommentQuery, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	metadata.EncodeCursors([]byte(a.config.cursor.secret))
+	if format == "html" {
+		err = a.renderComments(comments)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The ETag of a page is derived from its body. We don't send a
+	// Last-Modified for lists because a comment being deleted from the
+	// page would not move the latest update time forward
 	data := envelope {
 		"comments": comments,
 		"@metadata": metadata,
 	}
+	err = a.writeCachedJSON(w, r, data, nil, time.Time{})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies)listRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// make sure the comment we are getting the replies for exists
+	_, err = a.getVisibleComment(r, id)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var queryParametersData struct {
+		Depth int
+		data.Filters
+	}
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+
+	// depth=1 returns only the direct replies, anything higher
+	// returns a nested tree of replies to replies
+	queryParametersData.Depth = a.getSingleIntegerParameter(queryParameters, "depth", 1, v)
+	format := a.readFormatParameter(queryParameters, v)
+	queryParametersData.Filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	queryParametersData.Filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	queryParametersData.Filters.SortSafeList = []string {"id", "author", "score", "-id", "-author", "-score"}
+
+	v.Check(queryParametersData.Depth > 0, "depth", "must be greater than zero")
+	v.Check(queryParametersData.Depth <= 10, "depth", "must be a maximum of 10")
+	data.ValidateFilters(v, queryParametersData.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// moderators see the replies that are not approved too
+	status := data.CommentStatusApproved
+	moderator, err := a.userHasPermission(r, data.PermissionCommentsModerate)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if moderator {
+		status = ""
+	}
+
+	replies, metadata, err := a.commentModel.GetReplies(r.Context(), id, queryParametersData.Depth, status, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if format == "html" {
+		err = a.renderComments(replies)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	data := envelope {
+		"replies": replies,
+		"@metadata": metadata,
+	}
+	err = a.writeCachedJSON(w, r, data, nil, time.Time{})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// Check if the user making the request is allowed to change or delete
+// the comment. Authors can change their own comments and moderators
+// can change anybody's
+func (a *applicationDependencies)canModifyComment(r *http.Request, comment *data.Comment) (bool, error) {
+	user := a.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+	if comment.Author.ID == user.ID {
+		return true, nil
+	}
+
+	return a.userHasPermission(r, data.PermissionCommentsModerate)
+}
+
+// Get a comment if the user making the request is allowed to see it.
+// Comments that are not approved are only shown to their author and to
+// moderators, for everybody else they do not exist
+func (a *applicationDependencies)getVisibleComment(r *http.Request, id int64) (*data.Comment, error) {
+	comment, err := a.commentModel.Get(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Status == data.CommentStatusApproved {
+		return comment, nil
+	}
+
+	user := a.contextGetUser(r)
+	if !user.IsAnonymous() && comment.Author.ID == user.ID {
+		return comment, nil
+	}
+	moderator, err := a.userHasPermission(r, data.PermissionCommentsModerate)
+	if err != nil {
+		return nil, err
+	}
+	if !moderator {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return comment, nil
+}
+
+// Read ?format=, which is text (the default) or html
+func (a *applicationDependencies) readFormatParameter(queryParameters url.Values, v *validator.Validator) string {
+	format := a.getSingleQueryParameter(queryParameters, "format", "text")
+	v.Check(validator.PermittedValue(format, "text", "html"), "format", "must be text or html")
+	return format
+}
+
+// Fill in content_html for the comments and the replies nested under
+// them. Renderings are cached so a busy thread is only rendered once
+func (a *applicationDependencies) renderComments(comments []*data.Comment) error {
+	for _, comment := range comments {
+		html, err := a.markdown.RenderComment(comment.ID, comment.Version, comment.Content)
+		if err != nil {
+			return err
+		}
+		comment.ContentHTML = html
+
+		err = a.renderComments(comment.Replies)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A strong ETag for a single comment. Every change to the content
+// increments the version so the id and version identify it. Reactions
+// and moderation do not touch the version, so when the comment has
+// reactions or is not approved we add a hash of those to tell them apart.
+// The HTML rendering is a different body, so it gets its own ETag too
+func commentETag(comment *data.Comment) string {
+	if len(comment.Reactions) == 0 && comment.Status == data.CommentStatusApproved && comment.ContentHTML == "" {
+		return fmt.Sprintf(`"%d-%d"`, comment.ID, comment.Version)
+	}
+
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%s;", comment.Status)
+	if comment.ContentHTML != "" {
+		fmt.Fprint(hash, "html;")
+	}
+	for _, kind := range data.ReactionKinds {
+		fmt.Fprintf(hash, "%s=%d;", kind, comment.Reactions[kind])
+	}
+	return fmt.Sprintf(`"%d-%d-%x"`, comment.ID, comment.Version, hash.Sum32())
+}
+
+func (a *applicationDependencies)restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.commentModel.Restore(r.Context(), id)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			case errors.Is(err, data.ErrParentDeleted):
+				v := validator.New()
+				v.AddError("parent_id", "is deleted, restore the parent comment first")
+				a.failedValidationResponse(w, r, v.Errors)
+			default:
+				a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// send back the comment the way clients will now see it
+	comment, err := a.commentModel.Get(r.Context(), id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", commentETag(comment))
+	data := envelope {
+		"comment": comment,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// Permanently remove the deleted comments that are older than the
+// retention period right now instead of waiting for the background purge.
+// A retention of 0 turns purging off, it does not mean "purge everything"
+func (a *applicationDependencies)purgeCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.config.comments.retention <= 0 {
+		a.purgeDisabledResponse(w, r)
+		return
+	}
+
+	purged, err := a.commentModel.PurgeDeleted(r.Context(), a.config.comments.retention)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope {
+		"purged": purged,
+	}
 	err = a.writeJSON(w, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// Purge the deleted comments that are older than the retention period
+// once every interval. It runs until the application exits
+func (a *applicationDependencies)purgeDeletedComments(interval time.Duration) {
+	go func() {
+		// a panic in here would otherwise take the whole server down
+		defer func() {
+			err := recover()
+			if err != nil {
+				a.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		for {
+			time.Sleep(interval)
+			purged, err := a.commentModel.PurgeDeleted(context.Background(), a.config.comments.retention)
+			if err != nil {
+				a.logger.Error(err.Error())
+				continue
+			}
+			if purged > 0 {
+				a.logger.Info("purged deleted comments", "count", purged)
+			}
+		}
+	}()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/tchenbz/comments/internal/data"
+)
+
+func TestCreateComment(t *testing.T) {
+	app := newTestApplication(t)
+	writer := app.newTestUser(t, "alice", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+	reader := app.newTestUser(t, "bob", data.PermissionCommentsRead)
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+		// the field the validation error is about, for a 422
+		errorField string
+	}{
+		{name: "valid", token: writer, body: `{"content": "hello", "target_type": "article", "target_id": "1"}`, status: http.StatusCreated},
+		{name: "anonymous", body: `{"content": "hello", "target_type": "article", "target_id": "1"}`, status: http.StatusUnauthorized},
+		{name: "no write permission", token: reader, body: `{"content": "hello", "target_type": "article", "target_id": "1"}`, status: http.StatusForbidden},
+		{name: "no content", token: writer, body: `{"target_type": "article", "target_id": "1"}`, status: http.StatusUnprocessableEntity, errorField: "content"},
+		{name: "unknown target type", token: writer, body: `{"content": "hello", "target_type": "video", "target_id": "1"}`, status: http.StatusUnprocessableEntity, errorField: "target_type"},
+		{name: "missing parent", token: writer, body: `{"content": "hello", "parent_id": 999}`, status: http.StatusUnprocessableEntity, errorField: "parent_id"},
+		{name: "bad JSON", token: writer, body: `{"content": `, status: http.StatusBadRequest},
+		{name: "unknown field", token: writer, body: `{"content": "hello", "author": "mallory"}`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := app.testRequest(t, http.MethodPost, "/v1/comments", tt.token, tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d\n%s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.errorField != "" {
+				var response struct {
+					Error map[string]string `json:"error"`
+				}
+				readTestJSON(t, w, &response)
+				if response.Error[tt.errorField] == "" {
+					t.Errorf("got errors %v, want one for %s", response.Error, tt.errorField)
+				}
+			}
+		})
+	}
+
+	t.Run("stored", func(t *testing.T) {
+		comment := app.createTestComment(t, writer, `{"content": "stored", "target_type": "article", "target_id": "2"}`)
+		if comment.Author.Name != "alice" || comment.Status != data.CommentStatusApproved || comment.Version != 1 {
+			t.Errorf("got %+v", comment)
+		}
+
+		w := app.testRequest(t, http.MethodGet, "/v1/comments/"+strconv.FormatInt(comment.ID, 10), "", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var response struct {
+			Comment data.Comment `json:"comment"`
+		}
+		readTestJSON(t, w, &response)
+		if response.Comment.Content != "stored" || response.Comment.TargetID != "2" {
+			t.Errorf("got %+v", response.Comment)
+		}
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		parent := app.createTestComment(t, writer, `{"content": "parent", "target_type": "article", "target_id": "3"}`)
+		reply := app.createTestComment(t, writer, `{"content": "reply", "parent_id": `+strconv.FormatInt(parent.ID, 10)+`}`)
+		// a reply is about whatever its parent is about
+		if reply.ParentID == nil || *reply.ParentID != parent.ID || reply.TargetType != "article" || reply.TargetID != "3" {
+			t.Errorf("got %+v", reply)
+		}
+	})
+}
+
+func TestUpdateCommentPermissions(t *testing.T) {
+	app := newTestApplication(t)
+	author := app.newTestUser(t, "alice", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+	other := app.newTestUser(t, "bob", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+	moderator := app.newTestUser(t, "carol", data.PermissionCommentsRead, data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	comment := app.createTestComment(t, author, `{"content": "mine", "target_type": "article", "target_id": "1"}`)
+	path := "/v1/comments/" + strconv.FormatInt(comment.ID, 10)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized},
+		{name: "somebody else", token: other, status: http.StatusForbidden},
+		{name: "author", token: author, status: http.StatusOK},
+		{name: "moderator", token: moderator, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := app.testRequest(t, http.MethodPatch, path, tt.token, `{"content": "edited by `+tt.name+`"}`, nil)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d\n%s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+
+	// the refused edits left the comment alone
+	w := app.testRequest(t, http.MethodGet, path, "", "", nil)
+	var response struct {
+		Comment data.Comment `json:"comment"`
+	}
+	readTestJSON(t, w, &response)
+	if response.Comment.Content != "edited by moderator" || response.Comment.Version != 3 {
+		t.Errorf("got %q at version %d, want \"edited by moderator\" at version 3", response.Comment.Content, response.Comment.Version)
+	}
+}
+
+func TestUpdateCommentIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	author := app.newTestUser(t, "alice", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+
+	comment := app.createTestComment(t, author, `{"content": "first", "target_type": "article", "target_id": "1"}`)
+	other := app.createTestComment(t, author, `{"content": "other", "target_type": "article", "target_id": "1"}`)
+	path := "/v1/comments/" + strconv.FormatInt(comment.ID, 10)
+
+	w := app.testRequest(t, http.MethodGet, path, "", "", nil)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET sent no ETag")
+	}
+
+	// the edit that moves the comment past etag
+	w = app.testRequest(t, http.MethodPatch, path, author, `{"content": "second"}`, map[string]string{"If-Match": etag})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d\n%s", w.Code, http.StatusOK, w.Body.String())
+	}
+	newETag := w.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("got ETag %q after the edit, want a new one", newETag)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "stale ETag", headers: map[string]string{"If-Match": etag}, status: http.StatusConflict},
+		{name: "stale version", headers: map[string]string{"X-Expected-Version": "1"}, status: http.StatusConflict},
+		{name: "ETag of another comment", headers: map[string]string{"If-Match": `"` + strconv.FormatInt(other.ID, 10) + `-2"`}, status: http.StatusConflict},
+		{name: "invalid", headers: map[string]string{"If-Match": `"soon"`}, status: http.StatusBadRequest},
+		{name: "invalid in a list", headers: map[string]string{"If-Match": newETag + `, "soon"`}, status: http.StatusBadRequest},
+		// If-Match only uses the strong comparison
+		{name: "weak current ETag", headers: map[string]string{"If-Match": "W/" + newETag}, status: http.StatusConflict},
+		{name: "list of stale ETags", headers: map[string]string{"If-Match": etag + `, "` + strconv.FormatInt(other.ID, 10) + `-2"`}, status: http.StatusConflict},
+		{name: "current ETag", headers: map[string]string{"If-Match": newETag}, status: http.StatusOK},
+		// the edit above moved the comment to version 3
+		{name: "list with the current ETag", headers: map[string]string{"If-Match": etag + `, "` + strconv.FormatInt(comment.ID, 10) + `-3"`}, status: http.StatusOK},
+		{name: "any version", headers: map[string]string{"If-Match": "*"}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := app.testRequest(t, http.MethodPatch, path, author, `{"content": "`+tt.name+`"}`, tt.headers)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d\n%s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tchenbz/comments/internal/data"
+)
+
+// Use our own type for the context key so it cannot
+// collide with keys set by other packages
+type contextKey string
+
+const userContextKey = contextKey("user")
+const apiKeyContextKey = contextKey("apiKey")
+const requestIDContextKey = contextKey("requestID")
+
+// Return a copy of the request with the User added to its context
+func (a *applicationDependencies) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// Get the User from the request context. The authenticate middleware
+// always sets one, so a missing User means we have a bug
+func (a *applicationDependencies) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// Return a copy of the request with the APIKey the client
+// authenticated with added to its context
+func (a *applicationDependencies) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// Get the APIKey from the request context, or nil if the client
+// did not use one
+func (a *applicationDependencies) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// Return a copy of the request with its request ID added to the context
+func (a *applicationDependencies) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/tchenbz/comments/internal/data"
 )
 
 func (a *applicationDependencies)logError(r *http.Request, err error) {
 
 	method := r.Method
 	uri := r.URL.RequestURI()
-	a.logger.Error(err.Error(), "method", method, "uri", uri)
+	// the logger adds the request ID from the context
+	a.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 }
 
 func (a *applicationDependencies)errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
@@ -22,6 +25,12 @@ func (a *applicationDependencies)errorResponseJSON(w http.ResponseWriter, r *htt
 }
 
 func (a *applicationDependencies)serverErrorResponse(w http.ResponseWriter, r *http.Request,err error) {
+	// a query that was cancelled or ran out of time is not a bug,
+	// the client can try again once the database is less busy
+	if data.IsQueryCanceled(err) {
+		a.queryCanceledResponse(w, r, err)
+		return
+	}
 	a.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
 	a.errorResponseJSON(w, r, http.StatusInternalServerError, message)
@@ -49,3 +58,52 @@ func (a *applicationDependencies)rateLimitExceededResponse(w http.ResponseWriter
 	message := "rate limit exceeded"
 	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
 }
+
+func (a *applicationDependencies)invalidCredentialsResponse(w http.ResponseWriter, r *http.Request)  {
+	message := "invalid authentication credentials"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies)invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request)  {
+	// let the client know that we expect a bearer token
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies)invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request)  {
+	message := "invalid or revoked API key"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies)authenticationRequiredResponse(w http.ResponseWriter, r *http.Request)  {
+	// like any 401, tell the client how to authenticate
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "you must be authenticated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies)notPermittedResponse(w http.ResponseWriter, r *http.Request)  {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies)editConflictResponse(w http.ResponseWriter, r *http.Request)  {
+	message := "unable to update the record due to an edit conflict, please try again"
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+func (a *applicationDependencies)purgeDisabledResponse(w http.ResponseWriter, r *http.Request)  {
+	message := "purging is disabled because -comments-retention is 0"
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// The query was cancelled, either because it took longer than
+// -db-query-timeout or because the client went away. A client that
+// went away never sees the response but the access log does
+func (a *applicationDependencies)queryCanceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	a.logger.WarnContext(r.Context(), "database query canceled", "method", r.Method, "uri", r.URL.RequestURI(), "error", err.Error())
+	w.Header().Set("Retry-After", "1")
+	message := "the server took too long to process your request, please try again"
+	a.errorResponseJSON(w, r, http.StatusServiceUnavailable, message)
+}
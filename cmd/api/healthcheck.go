@@ -2,9 +2,17 @@ package main
 
 import (
 	//"encoding/json"
+	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/tchenbz/comments/internal/migrate"
 )
 
+// GET /v1/healthcheck is the liveness probe: it only tells whether the
+// process is up and answering, without touching the database. Use
+// /v1/readyz to find out if we can serve requests
 func (a *applicationDependencies) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 //fmt.Fprintln(w, "status: available")
 //fmt.Fprintf(w, "environment: %s\n", a.config.environment)
@@ -43,6 +51,13 @@ data := envelope {
 	},
 }
 
+// the memory backend has no database and so no pool to report
+database := map[string]any{"backend": a.config.db.backend}
+if a.dbPool != nil {
+	database["pool"] = a.dbPool
+}
+data["database"] = database
+
 err := a.writeJSON(w, http.StatusOK, data, nil)
 if err != nil {
 //a.logger.Error(err.Error())
@@ -53,3 +68,104 @@ a.serverErrorResponse(w, r, err)
 
 }
 
+// How long a single readiness check may take. A load balancer is better
+// off hearing "not ready" quickly than waiting on a struggling database
+const readinessTimeout = 2 * time.Second
+
+// The outcome of one readiness check
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	// the schema versions, only filled in by the migrations check
+	Version  int64 `json:"version,omitempty"`
+	Expected int64 `json:"expected,omitempty"`
+}
+
+func newDependencyStatus(start time.Time, err error) dependencyStatus {
+	status := dependencyStatus{
+		Status:    "up",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// GET /v1/readyz is the readiness probe. It pings the database and
+// checks that the schema is at the version this build expects, and
+// answers 503 if either fails. Once a shutdown signal has arrived it
+// answers 503 without checking anything
+func (a *applicationDependencies) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if a.shuttingDown.Load() {
+		err := a.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting_down"}, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the memory backend has nothing to check
+	checks := map[string]dependencyStatus{}
+	if a.db != nil {
+		checks["database"] = a.checkDatabase(r.Context())
+	}
+	if a.migrator != nil {
+		checks["migrations"] = a.checkMigrations(r.Context())
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Status != "up" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+
+	data := envelope{
+		"status": status,
+		"checks": checks,
+	}
+	err := a.writeJSON(w, code, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) checkDatabase(ctx context.Context) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := a.db.PingContext(ctx)
+	return newDependencyStatus(start, err)
+}
+
+// The schema must not be behind this build, or dirty. A newer schema
+// is fine, checkSchema lets us start with one too
+func (a *applicationDependencies) checkMigrations(ctx context.Context) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	version, dirty, err := a.migrator.VersionContext(ctx)
+	if err != nil {
+		return newDependencyStatus(start, err)
+	}
+	latest, err := a.migrator.Latest()
+	if err != nil {
+		return newDependencyStatus(start, err)
+	}
+	switch {
+	case dirty:
+		err = migrate.ErrDirty
+	case version < latest:
+		err = fmt.Errorf("the schema is at version %d but version %d is needed", version, latest)
+	}
+
+	status := newDependencyStatus(start, err)
+	status.Version = version
+	status.Expected = latest
+	return status
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/tchenbz/comments/internal/validator"
@@ -24,6 +26,72 @@ func (a *applicationDependencies)writeJSON(w http.ResponseWriter, status int, da
 	}
 
 	jsResponse = append(jsResponse, '\n')
+	return a.writeResponse(w, status, jsResponse, headers)
+}
+
+// writeCachedJSON works like writeJSON for a 200 OK response but also
+// sends the ETag and Last-Modified headers so that clients can make
+// conditional requests. If the headers do not already contain an ETag
+// we derive one from the body. When the client's copy is still current
+// we send 304 Not Modified without a body. A zero lastModified means
+// we don't send a Last-Modified header
+func (a *applicationDependencies)writeCachedJSON(w http.ResponseWriter, r *http.Request, data envelope, headers http.Header, lastModified time.Time) error {
+	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	jsResponse = append(jsResponse, '\n')
+
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	if headers.Get("ETag") == "" {
+		hash := sha256.Sum256(jsResponse)
+		headers.Set("ETag", fmt.Sprintf(`"%x"`, hash[:16]))
+	}
+	if !lastModified.IsZero() {
+		headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, headers.Get("ETag"), lastModified) {
+		for key, value := range headers {
+			w.Header()[key] = value
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return a.writeResponse(w, http.StatusOK, jsResponse, headers)
+}
+
+// Check the conditional request headers to see if the client already
+// has the current representation. If-None-Match wins over
+// If-Modified-Since when both are sent
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ifModifiedSince)
+		if err == nil {
+			// HTTP dates only have second precision
+			return !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+
+	return false
+}
+
+func (a *applicationDependencies)writeResponse(w http.ResponseWriter, status int, jsResponse []byte, headers http.Header) error {
 	//additional headers to be set
 	for key, value := range headers {
 		w.Header()[key] = value
@@ -33,7 +101,7 @@ func (a *applicationDependencies)writeJSON(w http.ResponseWriter, status int, da
 	w.Header().Set("Content-Type", "application/json")
 	//explicitly set the response status code
 	w.WriteHeader(status)
-	_, err = w.Write(jsResponse)
+	_, err := w.Write(jsResponse)
 	if err != nil {
 		return err
 	}
@@ -107,6 +175,17 @@ func (a *applicationDependencies)readIDParam(r *http.Request)(int64, error) {
 
 }
 
+// Get the :version URL parameter used by the revision routes
+func (a *applicationDependencies)readVersionParam(r *http.Request)(int32, error) {
+    params := httprouter.ParamsFromContext(r.Context())
+    version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+    if err != nil || version < 1 {
+        return 0, errors.New("invalid version parameter")
+    }
+
+    return int32(version), nil
+}
+
 func (a *applicationDependencies)getSingleQueryParameter(queryParameters url.Values, key string, defaultValue string) string {
 	// url.Values is a key:value hash map of the query parameters
     result := queryParameters.Get(key)
@@ -141,4 +220,90 @@ func (a *applicationDependencies)getSingleIntegerParameter(queryParameters url.V
 
    return intValue
 
-}
\ No newline at end of file
+}
+
+// this method can cause a validation error when trying to convert the
+// string to a valid boolean value
+func (a *applicationDependencies)getSingleBooleanParameter(queryParameters url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	result := queryParameters.Get(key)
+	if result == "" {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(result)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return boolValue
+}
+
+// Check if the user making the request has a specific permission. A
+// request made with an API key only gets the permissions in the key's
+// scopes, even when the user it belongs to has more
+func (a *applicationDependencies)userHasPermission(r *http.Request, code string) (bool, error) {
+	user := a.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+	key := a.contextGetAPIKey(r)
+	if key != nil && !key.Scopes.Include(code) {
+		return false, nil
+	}
+
+	permissions, err := a.permissionModel.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// Read the versions of a resource that the client expects to be
+// changing. It can be sent as an If-Match header holding a comma
+// separated list of the ETags we sent (e.g. If-Match: "42-3" or
+// "42-3-9f1c0e2a" once the comment has reactions) or just the version
+// (e.g. If-Match: "3"), or as an X-Expected-Version header. found is
+// false if the client sent neither or sent If-Match: * which matches
+// any version. If-Match uses the strong comparison, so a weak W/"..."
+// ETag never matches and neither does an ETag for a different
+// resource: they add no version to the list
+func (a *applicationDependencies)readExpectedVersion(r *http.Request, id int64) ([]int32, bool, error) {
+	value := r.Header.Get("If-Match")
+	header := "If-Match"
+	if value == "" {
+		value = r.Header.Get("X-Expected-Version")
+		header = "X-Expected-Version"
+	}
+	value = strings.TrimSpace(value)
+	if value == "" || value == "*" {
+		return nil, false, nil
+	}
+
+	var versions []int32
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		tag = strings.Trim(tag, `"`)
+		idPart, versionPart, isETag := strings.Cut(tag, "-")
+		if !isETag {
+			versionPart = tag
+		}
+		// the reactions part of the ETag has nothing to do with the version
+		versionPart, _, _ = strings.Cut(versionPart, "-")
+
+		version, err := strconv.ParseInt(versionPart, 10, 32)
+		if err != nil || version < 1 {
+			return nil, false, fmt.Errorf("invalid %s header", header)
+		}
+		if isETag && idPart != strconv.FormatInt(id, 10) {
+			continue
+		}
+		versions = append(versions, int32(version))
+	}
+
+	return versions, true, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/markdown"
+	"github.com/tchenbz/comments/internal/policy"
+)
+
+// An application wired to the memory stores, the same way main does
+// for -db memory, with the limiter off so tests can send any number of
+// requests. Comments go live right away and can be about articles.
+// handler is its routes, built once like serve does
+type testApplication struct {
+	*applicationDependencies
+	handler http.Handler
+}
+
+func newTestApplication(t *testing.T) *testApplication {
+	t.Helper()
+
+	var settings serverConfig
+	settings.db.backend = "memory"
+	settings.comments.defaultStatus = data.CommentStatusApproved
+	settings.cursor.secret = "test secret"
+	settings.targets.types = []string{"article"}
+
+	tokens := data.NewMemoryTokenStore()
+	permissions := data.NewMemoryPermissionStore()
+	comments := data.NewMemoryCommentStore()
+
+	app := &applicationDependencies{
+		config:          settings,
+		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		commentModel:    comments,
+		userModel:       data.NewMemoryUserStore(tokens, permissions),
+		tokenModel:      tokens,
+		permissionModel: permissions,
+		apiKeyModel:     data.NewMemoryAPIKeyStore(),
+		stats:           newServerMetrics(),
+		contentPolicy:   policy.Default(comments),
+		markdown:        markdown.New(100),
+	}
+
+	return &testApplication{applicationDependencies: app, handler: app.routes()}
+}
+
+// Add a user with the given permissions and return an authentication
+// token for them. The user is put in the store directly so the tests
+// don't wait on bcrypt
+func (a *testApplication) newTestUser(t *testing.T, name string, permissions ...string) string {
+	t.Helper()
+
+	user := &data.User{Name: name, Email: name + "@example.com", Activated: true}
+	err := a.userModel.Insert(user, permissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := a.tokenModel.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
+
+// Send a request through the routes and middleware. An empty token
+// sends it anonymously
+func (a *testApplication) testRequest(t *testing.T, method string, path string, token string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+
+	return w
+}
+
+// Decode the response body into dst, failing the test if it isn't JSON
+func readTestJSON(t *testing.T, w *httptest.ResponseRecorder, dst any) {
+	t.Helper()
+
+	err := json.Unmarshal(w.Body.Bytes(), dst)
+	if err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, w.Body.String())
+	}
+}
+
+// Post a comment as the user with the given token and return it
+func (a *testApplication) createTestComment(t *testing.T, token string, body string) *data.Comment {
+	t.Helper()
+
+	w := a.testRequest(t, http.MethodPost, "/v1/comments", token, body, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating %s: got status %d, want %d\n%s", body, w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var response struct {
+		Comment *data.Comment `json:"comment"`
+	}
+	readTestJSON(t, w, &response)
+
+	return response.Comment
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The Prometheus metrics of the server. They live in their own registry
+// rather than the global one so that nothing else can add to them
+type serverMetrics struct {
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+	inFlight    prometheus.Gauge
+	duration    *prometheus.HistogramVec
+	rateLimited *prometheus.CounterVec
+	panics      prometheus.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests being served right now.",
+		}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_rate_limited_total",
+			Help: "Number of requests turned away by the rate limiter, by the limiter that did it (ip or api_key).",
+		}, []string{"limiter"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_panics_total",
+			Help: "Number of panics recovered while serving requests.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.inFlight,
+		m.duration,
+		m.rateLimited,
+		m.panics,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Report the connection pool stats (open, idle and in use connections,
+// waits and so on) of the database as go_sql_*{db_name="comments"}
+func (m *serverMetrics) registerDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "comments"))
+}
+
+// The handler for GET /metrics in the Prometheus text format
+func (m *serverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// The route of a request is only known once the router has matched
+// it, which happens further down than the metrics middleware. The
+// middleware puts a routeLabel in the context and the route fills it in
+const routeLabelContextKey = contextKey("routeLabel")
+
+type routeLabel struct {
+	pattern string
+}
+
+// Wrap the handler of a route so that the request is labelled with
+// the route's pattern, e.g. /v1/comments/:id and not /v1/comments/42.
+// Labelling by the raw path would make a new time series for every id
+func (a *applicationDependencies) recordRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		label, ok := r.Context().Value(routeLabelContextKey).(*routeLabel)
+		if ok {
+			label.pattern = pattern
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Count every request and time it. Requests that never reached a
+// route (unknown paths, or turned away by the rate limiter or by
+// authenticate) are labelled "unmatched"
+func (a *applicationDependencies) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		a.stats.inFlight.Inc()
+		defer a.stats.inFlight.Dec()
+
+		label := &routeLabel{pattern: "unmatched"}
+		r = r.WithContext(context.WithValue(r.Context(), routeLabelContextKey, label))
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		code := strconv.Itoa(rec.statusCode)
+		a.stats.requests.WithLabelValues(label.pattern, r.Method, code).Inc()
+		a.stats.duration.WithLabelValues(label.pattern, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
@@ -1,20 +1,118 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"              // need this for the Mutex that we will need
 	"time"
 
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
 	"golang.org/x/time/rate"
 )
 
+// responseRecorder remembers the status code and the size of the
+// response for the middleware that report on it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if !rec.wroteHeader {
+		rec.statusCode = statusCode
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Give every request an ID so that everything logged about it can be
+// found again. A proxy in front of us may already have picked one, in
+// which case we keep it. The ID is sent back in the X-Request-ID header
+func (a *applicationDependencies)requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			randomBytes := make([]byte, 16)
+			_, err := rand.Read(randomBytes)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(randomBytes)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = a.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Only accept IDs that are safe to put in a log line and a header
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Log one line for every request once it has been served
+func (a *applicationDependencies)accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		a.logger.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rec.statusCode,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"ip", ip,
+		)
+	})
+}
+
 func (a *applicationDependencies)recoverPanic(next http.Handler)http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func ()  {
 			err := recover();
 			if err != nil {
+				a.stats.panics.Inc()
 				w.Header().Set("Connection", "close")
 				a.serverErrorResponse(w, r, fmt.Errorf("%s", err))
 			}
@@ -29,19 +127,45 @@ func (a *applicationDependencies)rateLimit(next http.Handler) http.Handler {
         lastSeen time.Time
     }
     var mu sync.Mutex    
+    // clients are keyed by IP address, or by "key:" and the hash of
+    // the API key for the services that send one
     var clients = make(map[string]*client)
     go func() {
         for {
             time.Sleep(time.Minute)
             mu.Lock() 
-            for ip, client := range clients {
+            for id, client := range clients {
                 if time.Since(client.lastSeen) > 3*time.Minute {
-                    delete(clients, ip)
+                    delete(clients, id)
                 }
             }
             mu.Unlock() 
         }
     }()
+    // allowKnown takes a token from the limiter of a client we have
+    // already seen and reports false for found if we have none, so the
+    // lookup and the token happen under one lock. allow does the same
+    // but creates the limiter with rps and burst if it is missing
+    allowKnown := func(id string) (allowed bool, found bool) {
+        mu.Lock()
+        defer mu.Unlock()
+        c, found := clients[id]
+        if !found {
+            return false, false
+        }
+        c.lastSeen = time.Now()
+        return c.limiter.Allow(), true
+    }
+    allow := func(id string, rps float64, burst int) bool {
+        mu.Lock()
+        defer mu.Unlock()
+        _, found := clients[id]
+        if !found {
+            clients[id] = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+        }
+        clients[id].lastSeen = time.Now()
+        return clients[id].limiter.Allow()
+    }
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         if a.config.limiter.enabled {
             ip, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -50,25 +174,201 @@ func (a *applicationDependencies)rateLimit(next http.Handler) http.Handler {
                 return
             }
 
-            mu.Lock() 
-            _, found := clients[ip]
-            if !found {
-                clients[ip] = &client{limiter: rate.NewLimiter(
-                    rate.Limit(a.config.limiter.rps),
-                    a.config.limiter.burst),
+            // A service with an API key gets the limits of its key instead
+            // of sharing the limit of its IP address. The first request of
+            // a key also counts against the IP, so made up keys can't be
+            // used to get around the limiter or to hammer the database
+            keyPlaintext := r.Header.Get("X-API-Key")
+            if keyPlaintext != "" {
+                hash := sha256.Sum256([]byte(keyPlaintext))
+                id := "key:" + hex.EncodeToString(hash[:])
+                allowed, found := allowKnown(id)
+                if !found {
+                    if !allow(ip, a.config.limiter.rps, a.config.limiter.burst) {
+                        a.stats.rateLimited.WithLabelValues("ip").Inc()
+                        a.rateLimitExceededResponse(w, r)
+                        return
+                    }
+                    key, err := a.apiKeyModel.GetForKey(keyPlaintext)
+                    if err != nil {
+                        switch {
+                        // authenticate turns the client away
+                        case errors.Is(err, data.ErrRecordNotFound):
+                            next.ServeHTTP(w, r)
+                        default:
+                            a.serverErrorResponse(w, r, err)
+                        }
+                        return
+                    }
+                    // hand the key to authenticate so it doesn't
+                    // look it up a second time
+                    r = a.contextSetAPIKey(r, key)
+                    allowed = allow(id, key.RPS, key.Burst)
+                }
+                if !allowed {
+                    a.stats.rateLimited.WithLabelValues("api_key").Inc()
+                    a.rateLimitExceededResponse(w, r)
+                    return
                 }
+                next.ServeHTTP(w, r)
+                return
             }
-            clients[ip].lastSeen = time.Now()
 
-            if !clients[ip].limiter.Allow() {
-                mu.Unlock() 
+            if !allow(ip, a.config.limiter.rps, a.config.limiter.burst) {
+                a.stats.rateLimited.WithLabelValues("ip").Inc()
                 a.rateLimitExceededResponse(w, r)
                 return
             }
-
-            mu.Unlock()
         } 
         next.ServeHTTP(w, r)
     })
 	 
-}
\ No newline at end of file
+}
+
+func (a *applicationDependencies)authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on the Authorization header so
+		// caches must not share it between clients
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		// other services send an API key instead of a token and act as
+		// the user the key belongs to
+		apiKeyHeader := r.Header.Get("X-API-Key")
+		if apiKeyHeader != "" {
+			if authorizationHeader != "" {
+				a.badRequestResponse(w, r, errors.New("send either an Authorization or an X-API-Key header, not both"))
+				return
+			}
+			a.authenticateAPIKey(w, r, apiKeyHeader, next)
+			return
+		}
+		// no header means this is an anonymous client
+		if authorizationHeader == "" {
+			r = a.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// we expect the header to look like "Bearer <token>"
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		token := headerParts[1]
+
+		v := validator.New()
+		data.ValidateTokenPlaintext(v, token)
+		if !v.IsEmpty() {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := a.userModel.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *applicationDependencies)authenticateAPIKey(w http.ResponseWriter, r *http.Request, keyPlaintext string, next http.Handler) {
+	v := validator.New()
+	data.ValidateAPIKeyPlaintext(v, keyPlaintext)
+	if !v.IsEmpty() {
+		a.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	// rateLimit has already looked up the key on its first request
+	key := a.contextGetAPIKey(r)
+	if key == nil {
+		var err error
+		key, err = a.apiKeyModel.GetForKey(keyPlaintext)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAPIKeyResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	user, err := a.userModel.Get(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidAPIKeyResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = a.contextSetUser(r, user)
+	r = a.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
+// Reject anonymous clients before they reach the handler
+func (a *applicationDependencies)requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.IsAnonymous() {
+			a.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Reject clients whose user account (or API key) does not have the
+// permission code. Anonymous clients are rejected by requireAuthenticatedUser first
+func (a *applicationDependencies)requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		allowed, err := a.userHasPermission(r, code)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !allowed {
+			a.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return a.requireAuthenticatedUser(fn)
+}
+
+// Like requirePermission, but anonymous clients are let through. The
+// public read routes use it: anybody may read the approved comments,
+// while a user or API key without the permission is still turned away
+func (a *applicationDependencies)allowAnonymousOrPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	permitted := a.requirePermission(code, next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.contextGetUser(r).IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		permitted.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/tchenbz/comments/internal/migrate"
+	"github.com/tchenbz/comments/migrations"
+)
+
+const migrateUsage = "usage: api [flags] migrate up|down [N]|status|version"
+
+func newMigrator(db *sql.DB, backend string) migrate.Migrator {
+	return migrate.Migrator{
+		DB:      db,
+		Files:   migrations.Files(backend),
+		Backend: backend,
+	}
+}
+
+// Run the migrate subcommand. up applies every pending migration, down
+// undoes the last N (1 if not given), status lists the migrations and
+// version prints the version the database is at
+func runMigrateCommand(settings serverConfig, logger *slog.Logger, args []string) error {
+	if settings.db.backend != "postgres" && settings.db.backend != "sqlite" {
+		return fmt.Errorf("the %q storage backend has no migrations", settings.db.backend)
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDB(settings, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator := newMigrator(db, settings.db.backend)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		fmt.Printf("applied %d migration(s)\n", applied)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New("the number of migrations to undo must be a positive integer")
+			}
+		}
+		undone, err := migrator.Down(steps)
+		fmt.Printf("undid %d migration(s)\n", undone)
+		return err
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%06d  %-8s %s\n", s.Version, state, s.Name)
+		}
+		return nil
+	case "version":
+		version, dirty, err := migrator.Version()
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+		} else {
+			fmt.Println(version)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// Make sure the database has every migration this build knows about.
+// Running against an older schema would fail on the first query that
+// uses a missing column, so we would rather not start at all
+func (a *applicationDependencies) checkSchema(migrator migrate.Migrator) error {
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return migrate.ErrDirty
+	}
+
+	latest, err := migrator.Latest()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version < latest:
+		return fmt.Errorf("the database schema is at version %d but version %d is needed, run \"api migrate up\" or start with -migrate", version, latest)
+	case version > latest:
+		// most likely a newer build already migrated the database
+		a.logger.Warn("the database schema is newer than this build", "version", version, "expected", latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// List the comments waiting for a moderator, oldest first. ?status=
+// picks a different state, e.g. flagged or rejected
+func (a *applicationDependencies) moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var queryParametersData struct {
+		data.CommentQuery
+		data.Filters
+	}
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+
+	queryParametersData.Status = a.getSingleQueryParameter(queryParameters, "status", data.CommentStatusPending)
+	queryParametersData.Filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	queryParametersData.Filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	queryParametersData.Filters.SortSafeList = []string{"id", "-id"}
+
+	v.Check(validator.PermittedValue(queryParametersData.Status, data.CommentStatuses...), "status", "invalid status value")
+	data.ValidateFilters(v, queryParametersData.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentModel.GetAll(r.Context(), queryParametersData.CommentQuery, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"comments":  comments,
+		"@metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) approveCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateComment(w, r, data.CommentStatusApproved)
+}
+
+func (a *applicationDependencies) rejectCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateComment(w, r, data.CommentStatusRejected)
+}
+
+// Approve or reject a comment. The body is optional and can give the
+// reason for the decision: {"reason": "spam"}
+func (a *applicationDependencies) moderateComment(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		err = a.readJSON(w, r, &incomingData)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	moderation := &data.CommentModeration{
+		CommentID:   id,
+		ModeratorID: a.contextGetUser(r).ID,
+		Status:      status,
+		Reason:      incomingData.Reason,
+	}
+
+	v := validator.New()
+	data.ValidateModeration(v, moderation)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.commentModel.Moderate(r.Context(), moderation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	comment, err := a.commentModel.Get(r.Context(), id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", commentETag(comment))
+	data := envelope{
+		"comment":    comment,
+		"moderation": moderation,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// PUT /v1/comments/:id/reactions/:kind adds the user's reaction and
+// DELETE takes it back. Both send back the comment with its new counts
+func (a *applicationDependencies) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeReaction(w, r, a.commentModel.AddReaction)
+}
+
+func (a *applicationDependencies) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeReaction(w, r, a.commentModel.RemoveReaction)
+}
+
+func (a *applicationDependencies) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, commentID int64, userID int64, kind string) error) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	kind := httprouter.ParamsFromContext(r.Context()).ByName("kind")
+
+	v := validator.New()
+	data.ValidateReactionKind(v, kind)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// nobody can react to a comment they are not allowed to see
+	_, err = a.getVisibleComment(r, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := a.contextGetUser(r)
+	err = change(r.Context(), id, user.ID, kind)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	comment, err := a.commentModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", commentETag(comment))
+	data := envelope{
+		"comment": comment,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/tchenbz/comments/internal/data"
+)
+
+// Post a thread of replies, each one answering the one before it, under
+// a new comment about the article. The top-level comment comes first.
+// The article is in the content too so the duplicate check leaves
+// threads about different articles alone
+func (a *testApplication) createTestThread(t *testing.T, token string, article string, length int) []*data.Comment {
+	t.Helper()
+
+	thread := []*data.Comment{a.createTestComment(t, token, `{"content": "top of `+article+`", "target_type": "article", "target_id": "`+article+`"}`)}
+	for i := 1; i < length; i++ {
+		parentID := strconv.FormatInt(thread[i-1].ID, 10)
+		thread = append(thread, a.createTestComment(t, token, `{"content": "reply `+strconv.Itoa(i)+` on `+article+`", "parent_id": `+parentID+`}`))
+	}
+
+	return thread
+}
+
+func commentPath(comment *data.Comment) string {
+	return "/v1/comments/" + strconv.FormatInt(comment.ID, 10)
+}
+
+func TestDeleteCommentWithReplies(t *testing.T) {
+	app := newTestApplication(t)
+	author := app.newTestUser(t, "alice", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+	other := app.newTestUser(t, "bob", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+	moderator := app.newTestUser(t, "carol", data.PermissionCommentsRead, data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	thread := app.createTestThread(t, author, "1", 4)
+	untouched := app.createTestThread(t, author, "2", 2)
+
+	w := app.testRequest(t, http.MethodDelete, commentPath(thread[0]), other, "", nil)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE by somebody else got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = app.testRequest(t, http.MethodDelete, commentPath(thread[0]), author, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE got status %d, want %d\n%s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// the replies at every level went with it, the other thread did not
+	for _, comment := range thread {
+		w = app.testRequest(t, http.MethodGet, commentPath(comment), "", "", nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %d got status %d, want %d", comment.ID, w.Code, http.StatusNotFound)
+		}
+	}
+	for _, comment := range untouched {
+		w = app.testRequest(t, http.MethodGet, commentPath(comment), "", "", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %d of the other thread got status %d, want %d", comment.ID, w.Code, http.StatusOK)
+		}
+	}
+
+	w = app.testRequest(t, http.MethodGet, commentPath(thread[0])+"/replies", "", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET replies of the deleted comment got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = app.testRequest(t, http.MethodGet, "/v1/comments?sort=id", "", "", nil)
+	var list struct {
+		Comments []*data.Comment `json:"comments"`
+	}
+	readTestJSON(t, w, &list)
+	want := []int64{untouched[0].ID, untouched[1].ID}
+	if got := commentIDs(list.Comments); !slices.Equal(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+
+	// deleting a reply that went with its parent finds nothing to delete
+	w = app.testRequest(t, http.MethodDelete, commentPath(thread[2]), author, "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a deleted reply got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// a reply can't come back while its parent is deleted, the
+	// whole thread comes back with the top-level comment
+	w = app.testRequest(t, http.MethodPost, commentPath(thread[1])+"/restore", moderator, "", nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("restoring a reply got status %d, want %d\n%s", w.Code, http.StatusUnprocessableEntity, w.Body.String())
+	}
+	w = app.testRequest(t, http.MethodPost, commentPath(thread[0])+"/restore", moderator, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restoring the thread got status %d, want %d\n%s", w.Code, http.StatusOK, w.Body.String())
+	}
+	for _, comment := range thread {
+		w = app.testRequest(t, http.MethodGet, commentPath(comment), "", "", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %d after the restore got status %d, want %d", comment.ID, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestListRepliesDepth(t *testing.T) {
+	app := newTestApplication(t)
+	author := app.newTestUser(t, "alice", data.PermissionCommentsRead, data.PermissionCommentsWrite)
+
+	thread := app.createTestThread(t, author, "1", 5)
+	sibling := app.createTestComment(t, author, `{"content": "sibling", "parent_id": `+strconv.FormatInt(thread[0].ID, 10)+`}`)
+	path := commentPath(thread[0]) + "/replies"
+
+	tests := []struct {
+		name  string
+		query string
+		// the ids at each level, walking down the first reply
+		want [][]int64
+	}{
+		{name: "default", query: "", want: [][]int64{{thread[1].ID, sibling.ID}}},
+		{name: "depth 1", query: "?depth=1", want: [][]int64{{thread[1].ID, sibling.ID}}},
+		{name: "depth 2", query: "?depth=2", want: [][]int64{{thread[1].ID, sibling.ID}, {thread[2].ID}}},
+		{name: "depth 3", query: "?depth=3", want: [][]int64{{thread[1].ID, sibling.ID}, {thread[2].ID}, {thread[3].ID}}},
+		{name: "deeper than the thread", query: "?depth=10", want: [][]int64{{thread[1].ID, sibling.ID}, {thread[2].ID}, {thread[3].ID}, {thread[4].ID}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := app.testRequest(t, http.MethodGet, path+tt.query, "", "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d\n%s", w.Code, http.StatusOK, w.Body.String())
+			}
+			var response struct {
+				Replies []*data.Comment `json:"replies"`
+			}
+			readTestJSON(t, w, &response)
+
+			var got [][]int64
+			for replies := response.Replies; len(replies) > 0; replies = replies[0].Replies {
+				got = append(got, commentIDs(replies))
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, depth := range []string{"0", "11", "deep"} {
+		t.Run("invalid depth "+depth, func(t *testing.T) {
+			w := app.testRequest(t, http.MethodGet, path+"?depth="+depth, "", "", nil)
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
+
+func commentIDs(comments []*data.Comment) []int64 {
+	ids := []int64{}
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+	}
+	return ids
+}
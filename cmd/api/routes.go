@@ -5,18 +5,61 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+
+	"github.com/tchenbz/comments/internal/data"
 )
 
 func (a *applicationDependencies)routes() http.Handler {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthCheckHandler) 
-	router.HandlerFunc(http.MethodGet, "/v1/comments/:id", a.displayCommentHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/comments", a.createCommentHandler)
-	router.HandlerFunc(http.MethodPatch,"/v1/comments/:id", a.updateCommentHandler)
-	router.HandlerFunc(http.MethodDelete,"/v1/comments/:id", a.deleteCommentHandler)
-	router.HandlerFunc(http.MethodGet,"/v1/comments", a.listCommentsHandler)
+	// every route records its pattern for the metrics
+	handle := func(method string, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, a.recordRoute(pattern, handler))
+	}
+	handle(http.MethodGet, "/v1/healthcheck", a.healthCheckHandler) 
+	handle(http.MethodGet, "/v1/readyz", a.readinessHandler)
+	handle(http.MethodGet, "/v1/comments/:id", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.displayCommentHandler))
+	handle(http.MethodPost, "/v1/comments", a.requirePermission(data.PermissionCommentsWrite, a.createCommentHandler))
+	handle(http.MethodPatch,"/v1/comments/:id", a.requirePermission(data.PermissionCommentsWrite, a.updateCommentHandler))
+	handle(http.MethodDelete,"/v1/comments/:id", a.requirePermission(data.PermissionCommentsWrite, a.deleteCommentHandler))
+	handle(http.MethodGet,"/v1/comments", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.listCommentsHandler))
+	handle(http.MethodGet,"/v1/comments/:id/replies", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.listRepliesHandler))
+	handle(http.MethodGet,"/v1/comments/:id/revisions", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.listRevisionsHandler))
+	handle(http.MethodGet,"/v1/comments/:id/revisions/:version", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.displayRevisionHandler))
+	handle(http.MethodPut,"/v1/comments/:id/reactions/:kind", a.requirePermission(data.PermissionCommentsWrite, a.addReactionHandler))
+	handle(http.MethodDelete,"/v1/comments/:id/reactions/:kind", a.requirePermission(data.PermissionCommentsWrite, a.removeReactionHandler))
+	handle(http.MethodPost,"/v1/comments/:id/reports", a.requirePermission(data.PermissionCommentsWrite, a.createReportHandler))
+	handle(http.MethodPost,"/v1/comments/:id/restore", a.requirePermission(data.PermissionCommentsModerate, a.restoreCommentHandler))
+	handle(http.MethodPost,"/v1/comments/:id/approve", a.requirePermission(data.PermissionCommentsModerate, a.approveCommentHandler))
+	handle(http.MethodPost,"/v1/comments/:id/reject", a.requirePermission(data.PermissionCommentsModerate, a.rejectCommentHandler))
+	handle(http.MethodPost,"/v1/moderation/purge", a.requirePermission(data.PermissionCommentsModerate, a.purgeCommentsHandler))
+	handle(http.MethodGet,"/v1/moderation/queue", a.requirePermission(data.PermissionCommentsModerate, a.moderationQueueHandler))
+	handle(http.MethodGet,"/v1/reports", a.requirePermission(data.PermissionCommentsModerate, a.listReportsHandler))
+	handle(http.MethodGet,"/v1/targets/:type/:id/comments", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.listCommentsHandler))
+	handle(http.MethodGet,"/v1/targets/:type/:id/comments/count", a.allowAnonymousOrPermission(data.PermissionCommentsRead, a.targetCommentCountHandler))
+	handle(http.MethodPost,"/v1/api-keys", a.requirePermission(data.PermissionAPIKeysManage, a.createAPIKeyHandler))
+	handle(http.MethodGet,"/v1/api-keys", a.requirePermission(data.PermissionAPIKeysManage, a.listAPIKeysHandler))
+	handle(http.MethodDelete,"/v1/api-keys/:id", a.requirePermission(data.PermissionAPIKeysManage, a.revokeAPIKeyHandler))
+	handle(http.MethodPost,"/v1/users", a.registerUserHandler)
+	handle(http.MethodPost,"/v1/tokens/authentication", a.createAuthenticationTokenHandler)
+
+	// without a separate -metrics-port Prometheus scrapes the API port
+	if a.config.metrics.port == 0 {
+		handle(http.MethodGet, "/metrics", a.stats.handler().ServeHTTP)
+	}
+
+	// the probes skip the limiter and authentication, a load balancer
+	// polling them must never be told to back off or to log in
+	protected := a.rateLimit(a.authenticate(router))
+	probes := map[string]bool{"/v1/healthcheck": true, "/v1/readyz": true}
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probes[r.URL.Path] {
+			router.ServeHTTP(w, r)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
 
-	return a.recoverPanic(a.rateLimit(router))
+	return a.requestID(a.accessLog(a.metrics(a.recoverPanic(mux))))
 }
\ No newline at end of file
@@ -20,14 +20,48 @@ func (a *applicationDependencies)serve() error {
         WriteTimeout: 10 * time.Second,
         ErrorLog: slog.NewLogLogger(a.logger.Handler(), slog.LevelError),
     }
+	// Prometheus can be kept off the public port by giving
+	// the metrics a port of their own, e.g. one only reachable inside
+	var metricsServer *http.Server
+	if a.config.metrics.port != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", a.stats.handler())
+		metricsServer = &http.Server{
+			Addr: fmt.Sprintf(":%d", a.config.metrics.port),
+			Handler: mux,
+			ReadTimeout: 5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog: slog.NewLogLogger(a.logger.Handler(), slog.LevelError),
+		}
+		go func() {
+			a.logger.Info("starting metrics server", "address", metricsServer.Addr)
+			err := metricsServer.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				a.logger.Error(err.Error())
+			}
+		}()
+	}
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1) 
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM) 
 		s := <-quit   
+		// fail the readiness check right away so the load balancer
+		// takes us out of rotation while we can still serve
+		a.shuttingDown.Store(true)
 		a.logger.Info("shutting down server", "signal", s.String())
+		// SIGTERM is what an orchestrator sends, a Ctrl-C (SIGINT) in
+		// a terminal has no load balancer to wait for
+		if s == syscall.SIGTERM && a.config.shutdownDelay > 0 {
+			a.logger.Info("waiting for the load balancer to drain", "delay", a.config.shutdownDelay)
+			time.Sleep(a.config.shutdownDelay)
+		}
 	   ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	   defer cancel()
+		if metricsServer != nil {
+			metricsServer.Shutdown(ctx)
+		}
 		shutdownError <- apiServer.Shutdown(ctx)
 		}()
  
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// GET /v1/targets/:type/:id/comments/count tells a page how many
+// comments it has without fetching them
+func (a *applicationDependencies) targetCommentCountHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	targetType := params.ByName("type")
+	targetID := params.ByName("id")
+
+	v := validator.New()
+	data.ValidateTarget(v, targetType, targetID, a.config.targets.types)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	count, err := a.commentModel.CountForTarget(r.Context(), targetType, targetID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"target_type": targetType,
+		"target_id":   targetID,
+		"count":       count,
+	}
+	err = a.writeCachedJSON(w, r, data, nil, time.Time{})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
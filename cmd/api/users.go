@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+func (a *applicationDependencies) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := &data.User{
+		Name:      incomingData.Name,
+		Email:     incomingData.Email,
+		Activated: false,
+	}
+
+	// The plaintext is checked before it is hashed: bcrypt refuses
+	// passwords longer than 72 bytes. The other fields are checked
+	// too so the client hears about every problem at once
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		data.ValidateName(v, user.Name)
+		data.ValidateEmail(v, user.Email)
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// hash the password before we validate so that ValidateUser
+	// can check that both the plaintext and the hash are present
+	err = user.Password.Set(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateUser(v, user)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// every new user can read and write comments
+	err = a.userModel.Insert(user, data.PermissionCommentsRead, data.PermissionCommentsWrite)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d", user.ID))
+
+	data := envelope{
+		"user": user,
+	}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,274 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// An APIKey lets another service call the API without logging in. The
+// service acts as the user the key belongs to, but only with the
+// permissions in Scopes, and gets its own rate limit. Like tokens, only
+// the hash is stored and the plaintext is shown once when the key is made
+type APIKey struct {
+	ID        int64       `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Plaintext string      `json:"key,omitempty"`
+	Hash      []byte      `json:"-"`
+	Name      string      `json:"name"`
+	UserID    int64       `json:"user_id"`
+	Scopes    Permissions `json:"scopes"`
+	RPS       float64     `json:"rps"`
+	Burst     int         `json:"burst"`
+	RevokedAt *time.Time  `json:"revoked_at,omitempty"`
+}
+
+// APIKeyStore is everything the handlers need from wherever the
+// API keys are kept
+type APIKeyStore interface {
+	Insert(key *APIKey) error
+	GetForKey(keyPlaintext string) (*APIKey, error)
+	GetAll() ([]*APIKey, error)
+	Revoke(id int64) error
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Make up the plaintext of a new key and its hash. 20 random bytes
+// give us a 32 character key once encoded
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(key.UserID > 0, "user_id", "must be provided")
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one permission")
+	for _, scope := range key.Scopes {
+		v.Check(validator.PermittedValue(scope, PermissionCodes...), "scopes", "must only contain "+strings.Join(PermissionCodes, ", "))
+	}
+	v.Check(key.RPS > 0, "rps", "must be greater than zero")
+	v.Check(key.Burst > 0, "burst", "must be greater than zero")
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(len(keyPlaintext) == 32, "key", "must be 32 bytes long")
+}
+
+// Make a new key and store it together with its scopes. The plaintext
+// is filled in so it can be sent to the client
+func (k APIKeyModel) Insert(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := k.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (hash, name, user_id, rps, burst)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`
+	args := []any{key.Hash, key.Name, key.UserID, key.RPS, key.Burst}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	// build the list of placeholders ($2, $3, ...) for the scopes
+	args = []any{key.ID}
+	placeholders := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		args = append(args, scope)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	query = fmt.Sprintf(`
+		INSERT INTO api_keys_permissions (api_key_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code IN (%s)
+		ON CONFLICT DO NOTHING
+		`, strings.Join(placeholders, ", "))
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get the key with this plaintext. Revoked keys do not match
+func (k APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT id, created_at, name, user_id, rps, burst
+		FROM api_keys
+		WHERE hash = $1 AND revoked_at IS NULL
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := k.DB.QueryRowContext(ctx, query, keyHash[:]).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.Name,
+		&key.UserID,
+		&key.RPS,
+		&key.Burst,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = k.attachScopes(ctx, []*APIKey{&key})
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// Get every key, revoked ones included, oldest first
+func (k APIKeyModel) GetAll() ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, name, user_id, rps, burst, revoked_at
+		FROM api_keys
+		ORDER BY id ASC
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := k.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.ID, &key.CreatedAt, &key.Name, &key.UserID, &key.RPS, &key.Burst, &key.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = k.attachScopes(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Fill in the Scopes of the keys with a single query
+func (k APIKeyModel) attachScopes(ctx context.Context, keys []*APIKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ids := make([]any, len(keys))
+	placeholders := make([]string, len(keys))
+	byID := make(map[int64]*APIKey, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		byID[key.ID] = key
+		key.Scopes = Permissions{}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT api_keys_permissions.api_key_id, permissions.code
+		FROM api_keys_permissions
+		INNER JOIN permissions ON api_keys_permissions.permission_id = permissions.id
+		WHERE api_keys_permissions.api_key_id IN (%s)
+		ORDER BY permissions.id ASC
+		`, strings.Join(placeholders, ", "))
+
+	rows, err := k.DB.QueryContext(ctx, query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var keyID int64
+		var code string
+		err := rows.Scan(&keyID, &code)
+		if err != nil {
+			return err
+		}
+		byID[keyID].Scopes = append(byID[keyID].Scopes, code)
+	}
+
+	return rows.Err()
+}
+
+// Revoke a key. The row is kept so the list still shows the key
+func (k APIKeyModel) Revoke(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND revoked_at IS NULL
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := k.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"crypto/sha256"
+	"slices"
+	"sync"
+)
+
+// MemoryAPIKeyStore keeps the API keys in memory, in the order they
+// were made
+type MemoryAPIKeyStore struct {
+	mu     sync.RWMutex
+	nextID int64
+	keys   []*APIKey
+}
+
+var _ APIKeyStore = (*MemoryAPIKeyStore)(nil)
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{}
+}
+
+// Give back a copy the caller can change without touching the store
+func copyAPIKey(key *APIKey) *APIKey {
+	clone := *key
+	clone.Scopes = slices.Clone(key.Scopes)
+	return &clone
+}
+
+func (m *MemoryAPIKeyStore) Insert(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	key.ID = m.nextID
+	key.CreatedAt = memoryNow()
+	// same as the INSERT ... SELECT in APIKeyModel, unknown
+	// codes are dropped and each code is kept once
+	var scopes Permissions
+	for _, code := range PermissionCodes {
+		if key.Scopes.Include(code) {
+			scopes = append(scopes, code)
+		}
+	}
+	key.Scopes = scopes
+
+	// we never keep the plaintext around
+	clone := copyAPIKey(key)
+	clone.Plaintext = ""
+	m.keys = append(m.keys, clone)
+
+	return nil
+}
+
+func (m *MemoryAPIKeyStore) GetForKey(keyPlaintext string) (*APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if string(key.Hash) == string(keyHash[:]) && key.RevokedAt == nil {
+			return copyAPIKey(key), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m *MemoryAPIKeyStore) GetAll() ([]*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, copyAPIKey(key))
+	}
+
+	return keys, nil
+}
+
+func (m *MemoryAPIKeyStore) Revoke(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.ID == id && key.RevokedAt == nil {
+			now := memoryNow()
+			key.RevokedAt = &now
+			return nil
+		}
+	}
+
+	return ErrRecordNotFound
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/tchenbz/comments/internal/validator"
@@ -12,72 +14,172 @@ import (
 
 type Comment struct {
 	ID int64				`json:"id"`
+	ParentID *int64			`json:"parent_id,omitempty"`
+	// what the comment is about, e.g. article 123. Replies are
+	// always about the same thing as the comment they reply to
+	TargetType string		`json:"target_type"`
+	TargetID string			`json:"target_id"`
 	Content string			`json:"content"`
-	Author string			`json:"author"`
+	// the content rendered from Markdown, only filled in when the
+	// client asks for ?format=html
+	ContentHTML string		`json:"content_html,omitempty"`
+	Author CommentAuthor	`json:"author"`
 	CreatedAt time.Time		`json:"-"`
+	UpdatedAt time.Time		`json:"-"`
+	DeletedAt *time.Time	`json:"deleted_at,omitempty"`
+	// one of the CommentStatus constants. Only approved comments
+	// are shown to everybody
+	Status string			`json:"status"`
 	Version int32			`json:"version"`
+	// upvotes and likes minus downvotes
+	Score int32				`json:"score"`
+	// how many reactions of each kind the comment has
+	Reactions map[string]int	`json:"reactions,omitempty"`
+	Replies []*Comment		`json:"replies,omitempty"`
 }
 
+// The user who wrote a comment. Name is a copy of the user's name
+// at the time the comment was written. Comments written before
+// comments were tied to accounts have an ID of 0, so do comments
+// whose user was deleted but their name is DeletedAuthorName
+type CommentAuthor struct {
+	ID int64		`json:"id"`
+	Name string		`json:"name"`
+}
+
+// The name the database gives the author of a comment when their
+// user is deleted, see migrations 000015 and sqlite/000007
+const DeletedAuthorName = "[deleted]"
+
+// The search criteria for listing comments
+type CommentQuery struct {
+	Content string
+	Author string
+	// only moderators get to see deleted comments
+	IncludeDeleted bool
+	// only return comments with this status, any status if empty
+	Status string
+	// only return the comments about this target, every target if empty
+	TargetType string
+	TargetID string
+}
+
+// CommentStore is everything the handlers need from wherever the
+// comments are kept. CommentModel keeps them in PostgreSQL,
+// SQLiteCommentStore in SQLite and MemoryCommentStore in memory
+// Every method takes the context of the request it is made for, so
+// the query is abandoned once nobody is waiting for the answer
+type CommentStore interface {
+	Insert(ctx context.Context, comment *Comment) error
+	Get(ctx context.Context, id int64) (*Comment, error)
+	Update(ctx context.Context, comment *Comment, flag bool) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, commentQuery CommentQuery, filters Filters) ([]*Comment, Metadata, error)
+	GetReplies(ctx context.Context, parentID int64, depth int, status string, filters Filters) ([]*Comment, Metadata, error)
+	Restore(ctx context.Context, id int64) error
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+	HasRecentDuplicate(ctx context.Context, authorID int64, content string, since time.Time, excludeID int64) (bool, error)
+	GetRevisions(ctx context.Context, commentID int64, filters Filters) ([]*CommentRevision, Metadata, error)
+	GetRevision(ctx context.Context, commentID int64, version int32) (*CommentRevision, error)
+	AddReaction(ctx context.Context, commentID int64, userID int64, kind string) error
+	RemoveReaction(ctx context.Context, commentID int64, userID int64, kind string) error
+	Moderate(ctx context.Context, moderation *CommentModeration) error
+	AddReport(ctx context.Context, report *Report, threshold int) error
+	GetOpenReports(ctx context.Context, filters Filters) ([]*Report, Metadata, error)
+	CountForTarget(ctx context.Context, targetType string, targetID string) (int, error)
+}
+
+// How long a query gets when CommentModel.QueryTimeout is not set
+const DefaultQueryTimeout = 3 * time.Second
+
 type CommentModel struct {
 	DB *sql.DB
+	// the most time a single query (or transaction) may take
+	QueryTimeout time.Duration
+}
+
+// Derive the context for a query from the caller's context. The query
+// is cancelled when the caller's context is, or after QueryTimeout
+func (c CommentModel) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.QueryTimeout
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 
 func ValidateComment(v *validator.Validator, comment *Comment) {
 // check if the Content field is empty
     v.Check(comment.Content != "", "content", "must be provided")
-// check if the Author field is empty
-    v.Check(comment.Author != "", "author", "must be provided")
 // check if the Content field is empty
     v.Check(len(comment.Content) <= 100, "content", "must not be more than 100 bytes long")
-// check if the Author field is empty
-     v.Check(len(comment.Author) <= 25, "author", "must not be more than 25 bytes long")
 
 }
 
 
 // Insert a new row in the comments table
 // Expects a pointer to the actual comment
-func (c CommentModel) Insert(comment *Comment) error {
+func (c CommentModel) Insert(ctx context.Context, comment *Comment) error {
 	// the SQL query to be executed against the database table
 	 query := `
-		 INSERT INTO comments (content, author)
-		 VALUES ($1, $2)
-		 RETURNING id, created_at, version
+		 INSERT INTO comments (content, author, user_id, parent_id, status, target_type, target_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id, created_at, updated_at, version
 		 `
-   // the actual values to replace $1, and $2
-	args := []any{comment.Content, comment.Author}
+   // the actual values to replace $1 to $7. A nil ParentID
+   // is stored as NULL which makes this a top-level comment
+	args := []any{comment.Content, comment.Author.Name, comment.Author.ID, comment.ParentID, comment.Status, comment.TargetType, comment.TargetID}
 
-// Create a context with a 3-second timeout. No database
-// operation should take more than 3 seconds or we will quit it
-ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+// Give the query QueryTimeout to finish. It is also stopped
+// as soon as ctx is, e.g. when the client goes away
+ctx, cancel := c.queryContext(ctx)
 defer cancel()
+
+// The comment and its first revision are written together so
+// we never end up with one and not the other
+tx, err := c.DB.BeginTx(ctx, nil)
+if err != nil {
+	return err
+}
+defer tx.Rollback()
+
 // execute the query against the comments database table. We ask for the the
 // id, created_at, and version to be sent back to us which we will use
 // to update the Comment struct later on 
-return c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Version)
+if err != nil {
+	return err
+}
+
+err = insertRevision(ctx, tx, comment)
+if err != nil {
+	return err
+}
+
+return tx.Commit()
 } 
 
 // Get a specific Comment from the comments table
-func (c CommentModel) Get(id int64) (*Comment, error) {
+func (c CommentModel) Get(ctx context.Context, id int64) (*Comment, error) {
 	// check if the id is valid
 	 if id < 1 {
 		 return nil, ErrRecordNotFound
 	 }
 	// the SQL query to be executed against the database table
 	 query := `
-		 SELECT id, created_at, content, author, version
+		 SELECT id, created_at, updated_at, content, COALESCE(user_id, 0), author, version, score, parent_id, target_type, target_id, status
 		 FROM comments
-		 WHERE id = $1
+		 WHERE id = $1 AND deleted_at IS NULL
 	   `
 	// declare a variable of type Comment to store the returned comment
    var comment Comment
 
-   // Set a 3-second context/timer
-   ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+   // Set a context/timer of QueryTimeout
+   ctx, cancel := c.queryContext(ctx)
    defer cancel()
    
-   err := c.DB.QueryRowContext(ctx, query, id).Scan(&comment.ID, &comment.CreatedAt, &comment.Content, &comment.Author, &comment.Version,)
+   err := c.DB.QueryRowContext(ctx, query, id).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Content, &comment.Author.ID, &comment.Author.Name, &comment.Version, &comment.Score, &comment.ParentID, &comment.TargetType, &comment.TargetID, &comment.Status)
    
    // check for which type of error
 	if err != nil {
@@ -88,41 +190,92 @@ func (c CommentModel) Get(id int64) (*Comment, error) {
 				return nil, err
 			}
 		}
+
+	err = c.attachReactions(ctx, []*Comment{&comment})
+	if err != nil {
+		return nil, err
+	}
+
 	return &comment, nil
 
 } 
 
 // Update a specific Comment from the comments table
-func (c CommentModel) Update(comment *Comment) error {
+// The update only happens if the version in the table is still the
+// version we read. If somebody else changed the comment in the meantime
+// no row matches and we report an edit conflict instead of overwriting.
+// flag holds an approved comment back for a moderator in the same update
+func (c CommentModel) Update(ctx context.Context, comment *Comment, flag bool) error {
 	// The SQL query to be executed against the database table
 	// Every time we make an update, we increment the version number
+	// The author of a comment never changes so we leave it alone.
+	// Moderation changes the status without bumping the version, so
+	// the status we read may be stale and is never written back
 		query := `
 			UPDATE comments
-			SET content = $1, author = $2, version = version + 1
-			WHERE id = $3
-			RETURNING version
+			SET content = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP,
+			    status = CASE WHEN $4 AND status = $5 THEN $6 ELSE status END
+			WHERE id = $2 AND version = $3 AND deleted_at IS NULL
+			RETURNING version, updated_at, status
 		  `
 
-		  args := []any{comment.Content, comment.Author, comment.ID}
-		  ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+		  args := []any{comment.Content, comment.ID, comment.Version, flag, CommentStatusApproved, CommentStatusFlagged}
+		  ctx, cancel := c.queryContext(ctx)
 		  defer cancel()
+
+		  // The new content is also written to the revision history in
+		  // the same transaction so the history can never miss an edit
+		  tx, err := c.DB.BeginTx(ctx, nil)
+		  if err != nil {
+			return err
+		  }
+		  defer tx.Rollback()
 	   
-		  return c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.Version)
+		  err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.Version, &comment.UpdatedAt, &comment.Status)
+		  if err != nil {
+			switch {
+				case errors.Is(err, sql.ErrNoRows):
+					return ErrEditConflict
+				default:
+					return err
+			}
+		  }
+
+		  err = insertRevision(ctx, tx, comment)
+		  if err != nil {
+			return err
+		  }
+
+		  return tx.Commit()
 }	
 
 // Delete a specific Comment from the comments table
-func (c CommentModel) Delete(id int64) error {
+// This is a soft delete: the comment is only marked as deleted so that
+// a moderator can restore it. Every reply below the comment in the
+// thread is marked as deleted along with it. The rows are removed for
+// good by PurgeDeleted once the retention period is over
+func (c CommentModel) Delete(ctx context.Context, id int64) error {
 
     // check if the id is valid
     if id < 1 {
         return ErrRecordNotFound
     }
    // the SQL query to be executed against the database table
+   // The content does not change so neither does the version
     query := `
-        DELETE FROM comments
-        WHERE id = $1
+        WITH RECURSIVE subtree AS (
+            SELECT id FROM comments
+            WHERE id = $1 AND deleted_at IS NULL
+            UNION ALL
+            SELECT c.id FROM comments c
+            INNER JOIN subtree s ON c.parent_id = s.id
+            WHERE c.deleted_at IS NULL
+        )
+        UPDATE comments
+        SET deleted_at = CURRENT_TIMESTAMP, deleted_with = $1
+        WHERE id IN (SELECT id FROM subtree)
       `
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := c.queryContext(ctx)
    	defer cancel()
 
 	// ExecContext does not return any rows unlike QueryRowContext. 
@@ -146,25 +299,32 @@ func (c CommentModel) Delete(id int64) error {
 	return nil
 }
 
-// Delete a specific Comment from the comments table
-func (c CommentModel) GetAll(content string, author string, filters Filters) ([]*Comment, Metadata, error) {
+// Get all the Comments that match the query
+func (c CommentModel) GetAll(ctx context.Context, commentQuery CommentQuery, filters Filters) ([]*Comment, Metadata, error) {
+	if filters.Cursor != nil {
+		return c.getAllByCursor(ctx, commentQuery, filters)
+	}
 
 	// the SQL query to be executed against the database table
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, content, author, version
+		SELECT COUNT(*) OVER(), id, created_at, updated_at, content, COALESCE(user_id, 0), author, version, score, parent_id, target_type, target_id, deleted_at, status
 		FROM comments
 		WHERE (to_tsvector('simple', content) @@
 			plainto_tsquery('simple', $1) OR $1 = '') 
 		AND (to_tsvector('simple', author) @@ 
 			plainto_tsquery('simple', $2) OR $2 = '') 
+		AND (deleted_at IS NULL OR $3)
+		AND (status = $4 OR $4 = '')
+		AND ((target_type = $7 AND target_id = $8) OR $7 = '')
 			ORDER BY %s %s, id ASC 
-			LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+			LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
 		
-	   ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	   ctx, cancel := c.queryContext(ctx)
 	   defer cancel()
 
 	   // QueryContext returns multiple rows.
-	   rows, err := c.DB.QueryContext(ctx, query, content, author, filters.limit(), filters.offset())
+	   rows, err := c.DB.QueryContext(ctx, query, commentQuery.Content, commentQuery.Author, commentQuery.IncludeDeleted, commentQuery.Status, filters.limit(), filters.offset(),
+		commentQuery.TargetType, commentQuery.TargetID)
 	   if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -178,8 +338,8 @@ func (c CommentModel) GetAll(content string, author string, filters Filters) ([]
 		// process each row that is in rows
 		for rows.Next() {
 			var comment Comment
-			err := rows.Scan(&totalRecords, &comment.ID, &comment.CreatedAt, &comment.Content, &comment.Author, &comment.Version,
-							 )
+			err := rows.Scan(&totalRecords, &comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Content, &comment.Author.ID, &comment.Author.Name,
+							 &comment.Version, &comment.Score, &comment.ParentID, &comment.TargetType, &comment.TargetID, &comment.DeletedAt, &comment.Status)
 			if err != nil {
 				return nil, Metadata{}, err
 			}
@@ -192,9 +352,302 @@ func (c CommentModel) GetAll(content string, author string, filters Filters) ([]
 		return nil, Metadata{}, err
 		}
 
+		err = c.attachReactions(ctx, comments)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
 		// Create the metadata
 		metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 
 		return comments, metadata, nil
 
-}	
\ No newline at end of file
+}
+
+// Get a page of the Comments that match the query using keyset pagination.
+// Instead of skipping rows with OFFSET we start right after the position
+// in the cursor, so every page costs the same no matter how deep it is
+func (c CommentModel) getAllByCursor(ctx context.Context, commentQuery CommentQuery, filters Filters) ([]*Comment, Metadata, error) {
+	condition, orderBy := filters.keyset("$8", "$9")
+
+	// we ask for one extra row to find out if there is another page
+	query := fmt.Sprintf(`
+		SELECT id, created_at, updated_at, content, COALESCE(user_id, 0), author, version, score, parent_id, target_type, target_id, deleted_at, status
+		FROM comments
+		WHERE (to_tsvector('simple', content) @@
+			plainto_tsquery('simple', $1) OR $1 = '')
+		AND (to_tsvector('simple', author) @@
+			plainto_tsquery('simple', $2) OR $2 = '')
+		AND (deleted_at IS NULL OR $3)
+		AND (status = $4 OR $4 = '')
+		AND ((target_type = $6 AND target_id = $7) OR $6 = '')
+		AND %s
+		ORDER BY %s
+		LIMIT $5`, condition, orderBy)
+
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	args := []any{commentQuery.Content, commentQuery.Author, commentQuery.IncludeDeleted, commentQuery.Status, filters.limit() + 1,
+		commentQuery.TargetType, commentQuery.TargetID}
+	// the first page has no position to start from so the
+	// condition does not use the cursor placeholders
+	if filters.Cursor.ID != 0 {
+		args = append(args, filters.Cursor.Value, filters.Cursor.ID)
+	}
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Content,
+			&comment.Author.ID, &comment.Author.Name, &comment.Version, &comment.Score, &comment.ParentID, &comment.TargetType, &comment.TargetID, &comment.DeletedAt, &comment.Status)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, &comment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = c.attachReactions(ctx, comments)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	hasMore := len(comments) > filters.limit()
+	if hasMore {
+		comments = comments[:filters.limit()]
+	}
+	// reading backwards gives us the page in reverse
+	if filters.Cursor.Backward {
+		slices.Reverse(comments)
+	}
+
+	return comments, cursorMetadata(comments, filters, hasMore), nil
+}
+
+// Undo the soft delete of a specific Comment. The replies that were
+// deleted together with it, which share its deleted_with, are
+// restored as well, replies that had
+// been deleted on their own before that stay deleted. A reply whose
+// parent is still deleted cannot be restored on its own: it would be a
+// live comment under a deleted one, and purging the parent would take
+// it along. ErrParentDeleted says to restore the parent first
+func (c CommentModel) Restore(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id, deleted_at, deleted_with FROM comments
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM comments p
+				WHERE p.id = comments.parent_id AND p.deleted_at IS NOT NULL
+			)
+			UNION ALL
+			SELECT c.id, c.deleted_at, c.deleted_with FROM comments c
+			INNER JOIN subtree s ON c.parent_id = s.id
+			WHERE c.deleted_at = s.deleted_at
+			AND COALESCE(c.deleted_with, 0) = COALESCE(s.deleted_with, 0)
+		)
+		UPDATE comments
+		SET deleted_at = NULL, deleted_with = NULL
+		WHERE id IN (SELECT id FROM subtree)
+		`
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// the comment does not exist, it was not deleted or its
+	// parent is deleted. Find out which so we can say so
+	if rowsAffected == 0 {
+		query = `
+			SELECT EXISTS (
+				SELECT 1 FROM comments c
+				INNER JOIN comments p ON p.id = c.parent_id
+				WHERE c.id = $1 AND c.deleted_at IS NOT NULL AND p.deleted_at IS NOT NULL
+			)
+			`
+		var parentDeleted bool
+		err = c.DB.QueryRowContext(ctx, query, id).Scan(&parentDeleted)
+		if err != nil {
+			return err
+		}
+		if parentDeleted {
+			return ErrParentDeleted
+		}
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Permanently remove the comments that were deleted more than
+// retention ago. It returns how many rows were removed
+func (c CommentModel) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM comments
+		WHERE deleted_at < $1
+		`
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(ctx, query, time.Now().Add(-retention).UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Report whether the author has a comment with exactly this content that
+// was posted since the given time. excludeID leaves out the comment being
+// edited so an edit is not a duplicate of itself
+func (c CommentModel) HasRecentDuplicate(ctx context.Context, authorID int64, content string, since time.Time, excludeID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM comments
+			WHERE user_id = $1 AND content = $2 AND created_at >= $3
+			AND id <> $4 AND deleted_at IS NULL
+		)
+		`
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	var exists bool
+	err := c.DB.QueryRowContext(ctx, query, authorID, content, since.UTC(), excludeID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Get the direct replies to a specific Comment. The replies themselves are
+// paginated using the filters. When depth is greater than 1 we also fetch
+// the replies to those replies (and so on) and nest them under their parent.
+// A status other than "" leaves out the replies (and everything below
+// them) that have a different status
+func (c CommentModel) GetReplies(ctx context.Context, parentID int64, depth int, status string, filters Filters) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, updated_at, content, COALESCE(user_id, 0), author, version, score, parent_id, target_type, target_id, status
+		FROM comments
+		WHERE parent_id = $1 AND deleted_at IS NULL AND (status = $4 OR $4 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, parentID, filters.limit(), filters.offset(), status)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	replies := []*Comment{}
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&totalRecords, &comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Content,
+			&comment.Author.ID, &comment.Author.Name, &comment.Version, &comment.Score, &comment.ParentID, &comment.TargetType, &comment.TargetID, &comment.Status)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		replies = append(replies, &comment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// only the first level is paginated, anything below it is
+	// loaded in full so that the tree is complete
+	if depth > 1 && len(replies) > 0 {
+		err = c.loadDescendants(ctx, replies, depth-1, status)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	err = c.attachReactions(ctx, replies)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return replies, metadata, nil
+}
+
+// Load up to levels generations of replies below the given comments and
+// attach each one to the Replies slice of its parent
+func (c CommentModel) loadDescendants(ctx context.Context, roots []*Comment, levels int, status string) error {
+	ids := make([]any, 0, len(roots))
+	placeholders := make([]string, 0, len(roots))
+	// keep track of every comment in the tree so that we can find a
+	// reply's parent no matter how deep it is
+	tree := make(map[int64]*Comment, len(roots))
+	for i, root := range roots {
+		ids = append(ids, root.ID)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+3))
+		tree[root.ID] = root
+	}
+
+	// The recursive part only walks the ids, the columns are read from
+	// comments afterwards. SQLite loses the column types of anything
+	// that goes through the CTE and could not scan the timestamps
+	query := fmt.Sprintf(`
+		WITH RECURSIVE descendants AS (
+			SELECT id, 1 AS level
+			FROM comments
+			WHERE parent_id IN (%s) AND deleted_at IS NULL AND (status = $2 OR $2 = '')
+			UNION ALL
+			SELECT c.id, d.level + 1
+			FROM comments c
+			INNER JOIN descendants d ON c.parent_id = d.id
+			WHERE d.level < $1 AND c.deleted_at IS NULL AND (c.status = $2 OR $2 = '')
+		)
+		SELECT c.id, c.created_at, c.updated_at, c.content, COALESCE(c.user_id, 0), c.author, c.version, c.score, c.parent_id, c.target_type, c.target_id, c.status
+		FROM descendants d
+		INNER JOIN comments c ON c.id = d.id
+		ORDER BY d.level ASC, c.id ASC`, strings.Join(placeholders, ", "))
+
+	rows, err := c.DB.QueryContext(ctx, query, append([]any{levels, status}, ids...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Content,
+			&comment.Author.ID, &comment.Author.Name, &comment.Version, &comment.Score, &comment.ParentID, &comment.TargetType, &comment.TargetID, &comment.Status)
+		if err != nil {
+			return err
+		}
+		// rows are ordered by level so the parent is always
+		// already in the tree by the time we see its replies
+		parent := tree[*comment.ParentID]
+		parent.Replies = append(parent.Replies, &comment)
+		tree[comment.ID] = &comment
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,625 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryCommentStore keeps the comments in memory instead of PostgreSQL.
+// It behaves the same way as CommentModel (soft deletes, revisions,
+// filtering, sorting and pagination) so the handlers can be run without
+// a database, but everything is lost when the application stops
+type MemoryCommentStore struct {
+	mu        sync.RWMutex
+	nextID    int64
+	comments  map[int64]*Comment
+	revisions map[int64][]*CommentRevision
+	reactions map[reactionKey]bool
+	// the deleted_with column, by comment ID
+	deletedWith map[int64]int64
+	// the comment_moderations table
+	moderations  []*CommentModeration
+	nextReportID int64
+	reports      []*Report
+}
+
+// The primary key of comment_reactions
+type reactionKey struct {
+	commentID int64
+	userID    int64
+	kind      string
+}
+
+var _ CommentStore = (*MemoryCommentStore)(nil)
+
+func NewMemoryCommentStore() *MemoryCommentStore {
+	return &MemoryCommentStore{
+		comments:    make(map[int64]*Comment),
+		revisions:   make(map[int64][]*CommentRevision),
+		reactions:   make(map[reactionKey]bool),
+		deletedWith: make(map[int64]int64),
+	}
+}
+
+// Return a copy of a stored comment so the caller cannot change
+// the store by changing the comment it was given
+func copyComment(comment *Comment) *Comment {
+	clone := *comment
+	clone.Replies = nil
+	clone.Reactions = maps.Clone(comment.Reactions)
+	return &clone
+}
+
+// The database only keeps timestamps to the second
+func memoryNow() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+func (m *MemoryCommentStore) Insert(ctx context.Context, comment *Comment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	now := memoryNow()
+
+	comment.ID = m.nextID
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	comment.Version = 1
+
+	m.comments[comment.ID] = copyComment(comment)
+	m.addRevision(comment)
+
+	return nil
+}
+
+func (m *MemoryCommentStore) Get(ctx context.Context, id int64) (*Comment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	comment, found := m.comments[id]
+	if !found || comment.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyComment(comment), nil
+}
+
+func (m *MemoryCommentStore) Update(ctx context.Context, comment *Comment, flag bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// same as the WHERE id = $2 AND version = $3 in CommentModel
+	stored, found := m.comments[comment.ID]
+	if !found || stored.DeletedAt != nil || stored.Version != comment.Version {
+		return ErrEditConflict
+	}
+
+	comment.Version++
+	comment.UpdatedAt = memoryNow()
+
+	stored.Content = comment.Content
+	// same as the CASE in CommentModel, the status we were given
+	// may be stale so only a flag from the content policy is written
+	if flag && stored.Status == CommentStatusApproved {
+		stored.Status = CommentStatusFlagged
+	}
+	comment.Status = stored.Status
+	stored.Version = comment.Version
+	stored.UpdatedAt = comment.UpdatedAt
+	m.addRevision(comment)
+
+	return nil
+}
+
+// Soft delete a comment and every reply below it
+func (m *MemoryCommentStore) Delete(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, found := m.comments[id]
+	if !found || comment.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	now := memoryNow()
+	m.walkSubtree(comment, func(c *Comment) bool {
+		if c.DeletedAt != nil {
+			return false
+		}
+		c.DeletedAt = &now
+		m.deletedWith[c.ID] = id
+		return true
+	})
+
+	return nil
+}
+
+// Undo a soft delete together with the replies deleted at the same time
+func (m *MemoryCommentStore) Restore(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, found := m.comments[id]
+	if !found || comment.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+	if comment.ParentID != nil && m.comments[*comment.ParentID].DeletedAt != nil {
+		return ErrParentDeleted
+	}
+
+	deletedWith := m.deletedWith[comment.ID]
+	m.walkSubtree(comment, func(c *Comment) bool {
+		if c.DeletedAt == nil || m.deletedWith[c.ID] != deletedWith {
+			return false
+		}
+		c.DeletedAt = nil
+		delete(m.deletedWith, c.ID)
+		return true
+	})
+
+	return nil
+}
+
+// Call fn on the comment and then on its replies, going down the
+// thread for as long as fn returns true
+func (m *MemoryCommentStore) walkSubtree(root *Comment, fn func(c *Comment) bool) {
+	if !fn(root) {
+		return
+	}
+	for _, comment := range m.comments {
+		if comment.ParentID != nil && *comment.ParentID == root.ID {
+			m.walkSubtree(comment, fn)
+		}
+	}
+}
+
+// Report whether the author posted this exact content since the given
+// time, leaving out the comment being edited
+func (m *MemoryCommentStore) HasRecentDuplicate(ctx context.Context, authorID int64, content string, since time.Time, excludeID int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, comment := range m.comments {
+		if comment.Author.ID == authorID && comment.Content == content && !comment.CreatedAt.Before(since) &&
+			comment.ID != excludeID && comment.DeletedAt == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *MemoryCommentStore) CountForTarget(ctx context.Context, targetType string, targetID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, comment := range m.comments {
+		if comment.TargetType == targetType && comment.TargetID == targetID &&
+			comment.DeletedAt == nil && comment.Status == CommentStatusApproved {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Remove the comments deleted more than retention ago. Like the
+// ON DELETE CASCADE in PostgreSQL this also removes every reply below
+// them, but only the expired comments themselves are counted
+func (m *MemoryCommentStore) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	// find every expired comment before removing anything, a reply
+	// that expired too would otherwise only be counted if the map
+	// happened to hand it out before its parent
+	var expired []*Comment
+	for _, comment := range m.comments {
+		if comment.DeletedAt != nil && comment.DeletedAt.Before(cutoff) {
+			expired = append(expired, comment)
+		}
+	}
+
+	for _, comment := range expired {
+		if _, found := m.comments[comment.ID]; found {
+			m.walkSubtree(comment, func(c *Comment) bool {
+				delete(m.comments, c.ID)
+				delete(m.revisions, c.ID)
+				delete(m.deletedWith, c.ID)
+				for key := range m.reactions {
+					if key.commentID == c.ID {
+						delete(m.reactions, key)
+					}
+				}
+				m.moderations = slices.DeleteFunc(m.moderations, func(moderation *CommentModeration) bool {
+					return moderation.CommentID == c.ID
+				})
+				m.reports = slices.DeleteFunc(m.reports, func(report *Report) bool {
+					return report.CommentID == c.ID
+				})
+				return true
+			})
+		}
+	}
+
+	return int64(len(expired)), nil
+}
+
+func (m *MemoryCommentStore) GetAll(ctx context.Context, commentQuery CommentQuery, filters Filters) ([]*Comment, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches := []*Comment{}
+	for _, comment := range m.comments {
+		if comment.DeletedAt != nil && !commentQuery.IncludeDeleted {
+			continue
+		}
+		if commentQuery.Status != "" && comment.Status != commentQuery.Status {
+			continue
+		}
+		if commentQuery.TargetType != "" && (comment.TargetType != commentQuery.TargetType || comment.TargetID != commentQuery.TargetID) {
+			continue
+		}
+		if !matchesWords(comment.Content, commentQuery.Content) || !matchesWords(comment.Author.Name, commentQuery.Author) {
+			continue
+		}
+		matches = append(matches, copyComment(comment))
+	}
+
+	sortComments(matches, filters)
+	comments, metadata := paginateComments(matches, filters)
+
+	return comments, metadata, nil
+}
+
+func (m *MemoryCommentStore) GetReplies(ctx context.Context, parentID int64, depth int, status string, filters Filters) ([]*Comment, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// index the visible replies by the comment they reply to
+	children := make(map[int64][]*Comment)
+	for _, comment := range m.comments {
+		if status != "" && comment.Status != status {
+			continue
+		}
+		if comment.ParentID != nil && comment.DeletedAt == nil {
+			children[*comment.ParentID] = append(children[*comment.ParentID], comment)
+		}
+	}
+
+	replies := []*Comment{}
+	for _, comment := range children[parentID] {
+		replies = append(replies, copyComment(comment))
+	}
+	sortComments(replies, filters)
+	replies, metadata := paginateComments(replies, filters)
+
+	attachReplies(replies, children, depth-1)
+
+	return replies, metadata, nil
+}
+
+// Fill in the Replies of each comment, levels deep, in id order
+func attachReplies(comments []*Comment, children map[int64][]*Comment, levels int) {
+	if levels < 1 {
+		return
+	}
+	for _, comment := range comments {
+		for _, reply := range children[comment.ID] {
+			comment.Replies = append(comment.Replies, copyComment(reply))
+		}
+		slices.SortFunc(comment.Replies, func(a, b *Comment) int {
+			return cmp.Compare(a.ID, b.ID)
+		})
+		attachReplies(comment.Replies, children, levels-1)
+	}
+}
+
+// Must be called with the write lock held
+func (m *MemoryCommentStore) addRevision(comment *Comment) {
+	m.revisions[comment.ID] = append(m.revisions[comment.ID], &CommentRevision{
+		CommentID: comment.ID,
+		Version:   comment.Version,
+		Content:   comment.Content,
+		CreatedAt: comment.UpdatedAt,
+	})
+}
+
+func (m *MemoryCommentStore) GetRevisions(ctx context.Context, commentID int64, filters Filters) ([]*CommentRevision, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// revisions are stored in version order
+	revisions := slices.Clone(m.revisions[commentID])
+	if filters.sortDirection() == "DESC" {
+		slices.Reverse(revisions)
+	}
+
+	metadata := calculateMetaData(len(revisions), filters.Page, filters.PageSize)
+	start := min(filters.offset(), len(revisions))
+	end := min(start+filters.limit(), len(revisions))
+
+	page := []*CommentRevision{}
+	for _, revision := range revisions[start:end] {
+		clone := *revision
+		page = append(page, &clone)
+	}
+
+	return page, metadata, nil
+}
+
+func (m *MemoryCommentStore) GetRevision(ctx context.Context, commentID int64, version int32) (*CommentRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, revision := range m.revisions[commentID] {
+		if revision.Version == version {
+			clone := *revision
+			return &clone, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m *MemoryCommentStore) AddReaction(ctx context.Context, commentID int64, userID int64, kind string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, found := m.comments[commentID]
+	if !found || comment.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	if opposing := opposingReaction(kind); opposing != "" {
+		m.removeReaction(comment, reactionKey{commentID, userID, opposing})
+	}
+	key := reactionKey{commentID, userID, kind}
+	if !m.reactions[key] {
+		m.reactions[key] = true
+		if comment.Reactions == nil {
+			comment.Reactions = make(map[string]int)
+		}
+		comment.Reactions[kind]++
+		comment.Score += reactionScore(kind)
+		comment.UpdatedAt = memoryNow()
+	}
+
+	return nil
+}
+
+func (m *MemoryCommentStore) RemoveReaction(ctx context.Context, commentID int64, userID int64, kind string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, found := m.comments[commentID]
+	if !found || comment.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+	if !m.removeReaction(comment, reactionKey{commentID, userID, kind}) {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Must be called with the write lock held. It reports if there
+// was a reaction to remove
+func (m *MemoryCommentStore) removeReaction(comment *Comment, key reactionKey) bool {
+	if !m.reactions[key] {
+		return false
+	}
+
+	delete(m.reactions, key)
+	comment.Reactions[key.kind]--
+	if comment.Reactions[key.kind] == 0 {
+		delete(comment.Reactions, key.kind)
+	}
+	// the same as the nil map CommentModel leaves when there are none
+	if len(comment.Reactions) == 0 {
+		comment.Reactions = nil
+	}
+	comment.Score -= reactionScore(key.kind)
+	comment.UpdatedAt = memoryNow()
+
+	return true
+}
+
+func (m *MemoryCommentStore) Moderate(ctx context.Context, moderation *CommentModeration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, found := m.comments[moderation.CommentID]
+	if !found || comment.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	comment.Status = moderation.Status
+	comment.UpdatedAt = memoryNow()
+	moderation.CreatedAt = memoryNow()
+	clone := *moderation
+	m.moderations = append(m.moderations, &clone)
+
+	for _, report := range m.reports {
+		if report.CommentID == comment.ID && report.ResolvedAt == nil {
+			report.ResolvedAt = &moderation.CreatedAt
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryCommentStore) AddReport(ctx context.Context, report *Report, threshold int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, found := m.comments[report.CommentID]
+	if !found || comment.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	open := 0
+	for _, existing := range m.reports {
+		if existing.CommentID != report.CommentID {
+			continue
+		}
+		if existing.ReporterID == report.ReporterID {
+			return ErrDuplicateReport
+		}
+		if existing.ResolvedAt == nil {
+			open++
+		}
+	}
+
+	m.nextReportID++
+	report.ID = m.nextReportID
+	report.CreatedAt = memoryNow()
+	clone := *report
+	m.reports = append(m.reports, &clone)
+	open++
+
+	if threshold > 0 && open >= threshold && comment.Status == CommentStatusApproved {
+		comment.Status = CommentStatusFlagged
+		comment.UpdatedAt = memoryNow()
+	}
+
+	return nil
+}
+
+func (m *MemoryCommentStore) GetOpenReports(ctx context.Context, filters Filters) ([]*Report, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// reports are stored in id order, the only order we sort by
+	reports := []*Report{}
+	for _, report := range m.reports {
+		if report.ResolvedAt == nil {
+			clone := *report
+			reports = append(reports, &clone)
+		}
+	}
+	if filters.sortDirection() == "DESC" {
+		slices.Reverse(reports)
+	}
+
+	metadata := calculateMetaData(len(reports), filters.Page, filters.PageSize)
+	start := min(filters.offset(), len(reports))
+	end := min(start+filters.limit(), len(reports))
+
+	return reports[start:end], metadata, nil
+}
+
+// An approximation of to_tsvector('simple', text) @@ plainto_tsquery('simple', query):
+// every word in the query has to be one of the words in the text
+func matchesWords(text string, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	queryWords := splitWords(query)
+	if len(queryWords) == 0 {
+		return false
+	}
+
+	textWords := splitWords(text)
+	for _, word := range queryWords {
+		if !slices.Contains(textWords, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Compare the sort key of a comment with a sort key value taken from a
+// Cursor or another comment
+func compareSortKey(comment *Comment, column string, value string) int {
+	switch column {
+	case "id":
+		other, _ := strconv.ParseInt(value, 10, 64)
+		return cmp.Compare(comment.ID, other)
+	case "score":
+		other, _ := strconv.ParseInt(value, 10, 32)
+		return cmp.Compare(comment.Score, int32(other))
+	default:
+		return strings.Compare(comment.sortValue(column), value)
+	}
+}
+
+// Compare a comment with a position in the sorted list the way
+// ORDER BY column direction, id ASC would. A negative result means
+// the comment comes before the position
+func comparePosition(comment *Comment, filters Filters, value string, id int64) int {
+	result := compareSortKey(comment, filters.sortColumn(), value)
+	if filters.sortDirection() == "DESC" {
+		result = -result
+	}
+	if result == 0 {
+		result = cmp.Compare(comment.ID, id)
+	}
+
+	return result
+}
+
+func sortComments(comments []*Comment, filters Filters) {
+	column := filters.sortColumn()
+	slices.SortFunc(comments, func(a, b *Comment) int {
+		return comparePosition(a, filters, b.sortValue(column), b.ID)
+	})
+}
+
+// Cut one page out of comments that are already sorted, using either
+// page numbers or the cursor in the filters
+func paginateComments(sorted []*Comment, filters Filters) ([]*Comment, Metadata) {
+	if filters.Cursor == nil {
+		metadata := calculateMetaData(len(sorted), filters.Page, filters.PageSize)
+		start := min(filters.offset(), len(sorted))
+		end := min(start+filters.limit(), len(sorted))
+		return sorted[start:end], metadata
+	}
+
+	cursor := filters.Cursor
+	if !cursor.Backward {
+		// keep what comes after the cursor
+		start := len(sorted)
+		for i, comment := range sorted {
+			if cursor.ID == 0 || comparePosition(comment, filters, cursor.Value, cursor.ID) > 0 {
+				start = i
+				break
+			}
+		}
+		end := min(start+filters.limit(), len(sorted))
+		hasMore := len(sorted)-start > filters.limit()
+		page := sorted[start:end]
+		return page, cursorMetadata(page, filters, hasMore)
+	}
+
+	// keep what comes before the cursor, closest to it
+	end := 0
+	for i, comment := range sorted {
+		if comparePosition(comment, filters, cursor.Value, cursor.ID) < 0 {
+			end = i + 1
+		}
+	}
+	start := max(end-filters.limit(), 0)
+	hasMore := start > 0
+	page := sorted[start:end]
+	return page, cursorMetadata(page, filters, hasMore)
+}
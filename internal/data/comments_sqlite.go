@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLiteCommentStore keeps the comments in a SQLite database. Most of the
+// queries in CommentModel work as they are. The full text search is
+// different: SQLite has no to_tsvector() so the words are looked up in
+// the comments_fts table (see migrations/sqlite) instead. PurgeDeleted
+// counts the comments itself, see there
+type SQLiteCommentStore struct {
+	CommentModel
+}
+
+var _ CommentStore = SQLiteCommentStore{}
+
+// Build the FTS5 query that matches the comments containing every word in
+// query, the same way plainto_tsquery('simple', query) does. Each word is
+// quoted so that nothing the client sends is read as FTS5 syntax
+func ftsQuery(query string) string {
+	words := splitWords(query)
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// Get all the Comments that match the query, using page numbers or the
+// cursor in the filters
+func (s SQLiteCommentStore) GetAll(ctx context.Context, commentQuery CommentQuery, filters Filters) ([]*Comment, Metadata, error) {
+	conditions := []string{"(deleted_at IS NULL OR $1)", "(status = $2 OR $2 = '')"}
+	args := []any{commentQuery.IncludeDeleted, commentQuery.Status}
+
+	// left out of the query when empty so SQLite can use comments_target_idx
+	if commentQuery.TargetType != "" {
+		args = append(args, commentQuery.TargetType, commentQuery.TargetID)
+		conditions = append(conditions, fmt.Sprintf("target_type = $%d AND target_id = $%d", len(args)-1, len(args)))
+	}
+
+	searches := []struct {
+		column string
+		query  string
+	}{
+		{"content", commentQuery.Content},
+		{"author", commentQuery.Author},
+	}
+	for _, search := range searches {
+		if search.query == "" {
+			continue
+		}
+		match := ftsQuery(search.query)
+		// a search without any words in it matches nothing
+		if match == "" {
+			conditions = append(conditions, "FALSE")
+			continue
+		}
+		args = append(args, match)
+		conditions = append(conditions, fmt.Sprintf(
+			"id IN (SELECT rowid FROM comments_fts WHERE %s MATCH $%d)", search.column, len(args)))
+	}
+
+	var orderBy, limits string
+	if filters.Cursor == nil {
+		orderBy = fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())
+		args = append(args, filters.limit(), filters.offset())
+		limits = fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	} else {
+		var condition string
+		condition, orderBy = filters.keyset(fmt.Sprintf("$%d", len(args)+1), fmt.Sprintf("$%d", len(args)+2))
+		if filters.Cursor.ID != 0 {
+			// the cursor keeps its value as text but the id and
+			// score columns have to be compared with a number
+			var value any = filters.Cursor.Value
+			if filters.sortColumn() == "id" || filters.sortColumn() == "score" {
+				value, _ = strconv.ParseInt(filters.Cursor.Value, 10, 64)
+			}
+			args = append(args, value, filters.Cursor.ID)
+		}
+		conditions = append(conditions, condition)
+		// we ask for one extra row to find out if there is another page
+		args = append(args, filters.limit()+1)
+		limits = fmt.Sprintf("LIMIT $%d", len(args))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, updated_at, content, COALESCE(user_id, 0), author, version, score, parent_id, target_type, target_id, deleted_at, status
+		FROM comments
+		WHERE %s
+		ORDER BY %s
+		%s`, strings.Join(conditions, " AND "), orderBy, limits)
+
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&totalRecords, &comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Content,
+			&comment.Author.ID, &comment.Author.Name, &comment.Version, &comment.Score, &comment.ParentID, &comment.TargetType, &comment.TargetID, &comment.DeletedAt, &comment.Status)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, &comment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = s.attachReactions(ctx, comments)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if filters.Cursor == nil {
+		return comments, calculateMetaData(totalRecords, filters.Page, filters.PageSize), nil
+	}
+
+	hasMore := len(comments) > filters.limit()
+	if hasMore {
+		comments = comments[:filters.limit()]
+	}
+	// reading backwards gives us the page in reverse
+	if filters.Cursor.Backward {
+		slices.Reverse(comments)
+	}
+
+	return comments, cursorMetadata(comments, filters, hasMore), nil
+}
+
+// Permanently remove the comments that were deleted more than
+// retention ago. SQLite runs ON DELETE CASCADE for each row while the
+// DELETE is still going, so an expired reply that went with its parent
+// is missing from RowsAffected. The expired comments are counted first,
+// in the same transaction
+func (s SQLiteCommentStore) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := time.Now().Add(-retention).UTC()
+
+	var purged int64
+	query := `
+		SELECT COUNT(*) FROM comments
+		WHERE deleted_at < $1
+		`
+	err = tx.QueryRowContext(ctx, query, cutoff).Scan(&purged)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		DELETE FROM comments
+		WHERE deleted_at < $1
+		`
+	_, err = tx.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// A Cursor marks a position in a sorted list for keyset pagination.
+// It remembers the sort it was issued for, the sort key and id of the
+// comment at the edge of the page the client saw, and which direction
+// to continue in. A Cursor with an ID of 0 is the start of the list
+type Cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// Turn the cursor into an opaque string for the client. The payload is
+// signed so that clients cannot craft cursors of their own
+func (c Cursor) Encode(secret []byte) string {
+	payload, _ := json.Marshal(c)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signCursor(encoded, secret))
+}
+
+// Check the signature of a cursor string and turn it back into a Cursor
+func DecodeCursor(token string, secret []byte) (Cursor, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, signCursor(encoded, secret)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(payload, &cursor)
+	if err != nil || cursor.ID < 1 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func signCursor(encoded string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// The value of the column the comments are sorted by, the way
+// it is stored in a Cursor
+func (comment *Comment) sortValue(column string) string {
+	switch column {
+	case "author":
+		return comment.Author.Name
+	case "score":
+		return strconv.FormatInt(int64(comment.Score), 10)
+	default:
+		return strconv.FormatInt(comment.ID, 10)
+	}
+}
+
+// Work out the cursors for the pages on either side of a page of
+// comments. hasMore reports if there were more rows in the direction
+// we were reading in
+func cursorMetadata(comments []*Comment, filters Filters, hasMore bool) Metadata {
+	metadata := Metadata{PageSize: filters.PageSize}
+	if len(comments) == 0 {
+		return metadata
+	}
+
+	column := filters.sortColumn()
+	first := comments[0]
+	last := comments[len(comments)-1]
+
+	// going forwards there is a previous page unless we started at the
+	// beginning, going backwards there is always a next page
+	hasNext := hasMore
+	hasPrev := filters.Cursor.ID != 0
+	if filters.Cursor.Backward {
+		hasNext = true
+		hasPrev = hasMore
+	}
+
+	if hasNext {
+		metadata.Next = &Cursor{Sort: filters.Sort, Value: last.sortValue(column), ID: last.ID}
+	}
+	if hasPrev {
+		metadata.Prev = &Cursor{Sort: filters.Sort, Value: first.sortValue(column), ID: first.ID, Backward: true}
+	}
+
+	return metadata
+}
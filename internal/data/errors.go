@@ -1,7 +1,26 @@
 package data 
 
 import (
+    "context"
     "errors"
+    "strings"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
+var ErrDuplicateEmail = errors.New("duplicate email")
+var ErrEditConflict = errors.New("edit conflict")
+var ErrDuplicateReport = errors.New("duplicate report")
+var ErrParentDeleted = errors.New("parent deleted")
+
+// IsQueryCanceled reports whether a query failed because its context was
+// cancelled or ran out of time. database/sql returns the context's error
+// when it notices first, otherwise the driver says so in its own words:
+// PostgreSQL cancels the statement and SQLite interrupts it
+func IsQueryCanceled(err error) bool {
+    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    message := err.Error()
+    return strings.Contains(message, "pq: canceling statement due to user request") ||
+        strings.Contains(message, "interrupted (9)")
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/tchenbz/comments/internal/validator"
@@ -13,6 +14,8 @@ type Filters struct {
     PageSize  int      // how records per page
 	Sort string
     SortSafeList  []string     // allowed sort fields
+    // When Cursor is set we use keyset pagination and ignore Page
+    Cursor *Cursor
 }
 
 // define a type to hold the metadata
@@ -22,13 +25,34 @@ type Metadata struct {
     FirstPage     int    `json:"first_page,omitempty"`
     LastPage      int    `json:"last_page,omitempty"`
     TotalRecords  int    `json:"total_records,omitempty"`
+    // Only used with keyset pagination. Next and Prev are turned into
+    // the signed NextCursor and PrevCursor strings before we send them
+    NextCursor    string  `json:"next_cursor,omitempty"`
+    PrevCursor    string  `json:"prev_cursor,omitempty"`
+    Next          *Cursor `json:"-"`
+    Prev          *Cursor `json:"-"`
+}
+
+// Fill in NextCursor and PrevCursor from Next and Prev
+func (m *Metadata) EncodeCursors(secret []byte) {
+    if m.Next != nil {
+        m.NextCursor = m.Next.Encode(secret)
+    }
+    if m.Prev != nil {
+        m.PrevCursor = m.Prev.Encode(secret)
+    }
 }
 
 // Next we validate page and PageSize
 // We follow the same approach that we used to validate a Comment
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 500, "page", "must be a maximum of 500")
+	// page numbers are meaningless when paginating with a cursor
+	if f.Cursor == nil {
+		v.Check(f.Page > 0, "page", "must be greater than zero")
+		v.Check(f.Page <= 500, "page", "must be a maximum of 500")
+	} else {
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "was issued for a different sort")
+	}
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	// Check if sort fields provided are valid
@@ -83,3 +107,46 @@ func (f Filters) sortDirection() string {
 	}
 	return "ASC"
 }
+
+// Build the WHERE condition that only keeps the rows after the cursor
+// and the ORDER BY that walks them for keyset pagination. valueParam and
+// idParam are the placeholders holding the cursor's sort value and id.
+// Ties on the sort column are always broken by id ASC, so reading
+// backwards means flipping both columns and reversing the page afterwards
+func (f Filters) keyset(valueParam string, idParam string) (string, string) {
+	column := f.sortColumn()
+	direction := f.sortDirection()
+	ascending := direction == "ASC"
+	idDirection := "ASC"
+
+	if f.Cursor.Backward {
+		ascending = !ascending
+		idDirection = "DESC"
+		if direction == "ASC" {
+			direction = "DESC"
+		} else {
+			direction = "ASC"
+		}
+	}
+
+	orderBy := fmt.Sprintf("%s %s, id %s", column, direction, idDirection)
+
+	// the first page has nothing to skip
+	if f.Cursor.ID == 0 {
+		return "TRUE", orderBy
+	}
+
+	columnOperator := "<"
+	if ascending {
+		columnOperator = ">"
+	}
+	idOperator := ">"
+	if idDirection == "DESC" {
+		idOperator = "<"
+	}
+
+	condition := fmt.Sprintf("(%s %s %s OR (%s = %s AND id %s %s))",
+		column, columnOperator, valueParam, column, valueParam, idOperator, idParam)
+
+	return condition, orderBy
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// The moderation states a comment can be in. New comments start out
+// as pending or approved depending on how the server is configured
+const (
+	CommentStatusPending  = "pending"
+	CommentStatusApproved = "approved"
+	CommentStatusRejected = "rejected"
+	CommentStatusFlagged  = "flagged"
+)
+
+var CommentStatuses = []string{CommentStatusPending, CommentStatusApproved, CommentStatusRejected, CommentStatusFlagged}
+
+// A CommentModeration records a moderator approving or rejecting a
+// comment. Every decision is kept, not only the last one
+type CommentModeration struct {
+	CommentID   int64     `json:"comment_id"`
+	ModeratorID int64     `json:"moderator_id"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func ValidateModeration(v *validator.Validator, moderation *CommentModeration) {
+	v.Check(validator.PermittedValue(moderation.Status, CommentStatusApproved, CommentStatusRejected), "status", "must be approved or rejected")
+	v.Check(len(moderation.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// Change the status of a comment and record who did it and why. The
+// content does not change so neither does the version. Any open reports
+// on the comment are resolved by the decision
+func (c CommentModel) Moderate(ctx context.Context, moderation *CommentModeration) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE comments
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND deleted_at IS NULL
+		`
+	result, err := tx.ExecContext(ctx, query, moderation.Status, moderation.CommentID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		UPDATE comment_reports
+		SET resolved_at = CURRENT_TIMESTAMP
+		WHERE comment_id = $1 AND resolved_at IS NULL
+		`
+	_, err = tx.ExecContext(ctx, query, moderation.CommentID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO comment_moderations (comment_id, moderator_id, status, reason)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+		`
+	args := []any{moderation.CommentID, moderation.ModeratorID, moderation.Status, moderation.Reason}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&moderation.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The permission codes that the application knows about
+const (
+	PermissionCommentsRead     = "comments:read"
+	PermissionCommentsWrite    = "comments:write"
+	PermissionCommentsModerate = "comments:moderate"
+	PermissionAPIKeysManage    = "api_keys:manage"
+)
+
+// Every permission code that the migrations create
+var PermissionCodes = []string{
+	PermissionCommentsRead,
+	PermissionCommentsWrite,
+	PermissionCommentsModerate,
+	PermissionAPIKeysManage,
+}
+
+// The permission codes that a single user has
+type Permissions []string
+
+// Check if a specific permission code is in the slice
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// PermissionStore is everything the handlers need from wherever the
+// permissions are kept
+type PermissionStore interface {
+	GetAllForUser(userID int64) (Permissions, error)
+	AddForUser(userID int64, codes ...string) error
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// Get all the permission codes for a specific user
+func (p PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		INNER JOIN users ON users_permissions.user_id = users.id
+		WHERE users.id = $1
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// Give a user one or more permissions. Codes that do not exist
+// in the permissions table are ignored
+func (p PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addPermissions(ctx, p.DB, userID, codes)
+}
+
+// The part of *sql.DB and *sql.Tx that addPermissions needs, so the
+// permissions can be granted inside someone else's transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func addPermissions(ctx context.Context, db execer, userID int64, codes []string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	// build the list of placeholders ($2, $3, ...) for the codes
+	args := []any{userID}
+	placeholders := make([]string, len(codes))
+	for i, code := range codes {
+		args = append(args, code)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO users_permissions (user_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code IN (%s)
+		ON CONFLICT DO NOTHING
+		`, strings.Join(placeholders, ", "))
+
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"slices"
+	"sync"
+)
+
+// MemoryPermissionStore keeps the permissions of each user in memory.
+// Only the codes that the permissions migration creates can be granted
+type MemoryPermissionStore struct {
+	mu          sync.RWMutex
+	permissions map[int64]Permissions
+}
+
+var _ PermissionStore = (*MemoryPermissionStore)(nil)
+
+func NewMemoryPermissionStore() *MemoryPermissionStore {
+	return &MemoryPermissionStore{
+		permissions: make(map[int64]Permissions),
+	}
+}
+
+func (m *MemoryPermissionStore) GetAllForUser(userID int64) (Permissions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Clone(m.permissions[userID]), nil
+}
+
+func (m *MemoryPermissionStore) AddForUser(userID int64, codes ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, code := range codes {
+		if slices.Contains(PermissionCodes, code) && !m.permissions[userID].Include(code) {
+			m.permissions[userID] = append(m.permissions[userID], code)
+		}
+	}
+
+	return nil
+}
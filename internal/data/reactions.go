@@ -0,0 +1,240 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// The kinds of reaction a user can leave on a comment
+const (
+	ReactionLike     = "like"
+	ReactionUpvote   = "upvote"
+	ReactionDownvote = "downvote"
+)
+
+var ReactionKinds = []string{ReactionLike, ReactionUpvote, ReactionDownvote}
+
+// How much a reaction of each kind adds to the score of a comment
+func reactionScore(kind string) int32 {
+	if kind == ReactionDownvote {
+		return -1
+	}
+	return 1
+}
+
+// A user can upvote or downvote a comment but not both, so adding one
+// takes the other away. Likes do not exclude anything
+func opposingReaction(kind string) string {
+	switch kind {
+	case ReactionUpvote:
+		return ReactionDownvote
+	case ReactionDownvote:
+		return ReactionUpvote
+	default:
+		return ""
+	}
+}
+
+func ValidateReactionKind(v *validator.Validator, kind string) {
+	v.Check(validator.PermittedValue(kind, ReactionKinds...), "kind", "must be one of "+strings.Join(ReactionKinds, ", "))
+}
+
+// Lock the comment row for the rest of the transaction so that the
+// score is updated by one reaction at a time. It also tells us if the
+// comment exists and is not deleted
+func lockComment(ctx context.Context, tx *sql.Tx, commentID int64) error {
+	query := `
+		UPDATE comments
+		SET score = score
+		WHERE id = $1 AND deleted_at IS NULL
+		`
+	result, err := tx.ExecContext(ctx, query, commentID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Remove a reaction inside a transaction and return how much the
+// score of the comment changed because of it
+func deleteReaction(ctx context.Context, tx *sql.Tx, commentID int64, userID int64, kind string) (int32, error) {
+	query := `
+		DELETE FROM comment_reactions
+		WHERE comment_id = $1 AND user_id = $2 AND kind = $3
+		`
+	result, err := tx.ExecContext(ctx, query, commentID, userID, kind)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return -reactionScore(kind) * int32(rowsAffected), nil
+}
+
+// Change the score of a comment. Every reaction that was added or
+// taken away changes the score, so a delta of 0 means nothing changed.
+// updated_at moves too since it is the Last-Modified of the comment
+// and the reaction counts are part of what the client was sent
+func adjustScore(ctx context.Context, tx *sql.Tx, commentID int64, delta int32) error {
+	if delta == 0 {
+		return nil
+	}
+	query := `
+		UPDATE comments
+		SET score = score + $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		`
+	_, err := tx.ExecContext(ctx, query, delta, commentID)
+	return err
+}
+
+// Record a user's reaction to a comment. Reacting the same way twice
+// does nothing. Reactions change the score of the comment but not its
+// version since the content stays the same
+func (c CommentModel) AddReaction(ctx context.Context, commentID int64, userID int64, kind string) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockComment(ctx, tx, commentID)
+	if err != nil {
+		return err
+	}
+
+	var delta int32
+	if opposing := opposingReaction(kind); opposing != "" {
+		delta, err = deleteReaction(ctx, tx, commentID, userID, opposing)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO comment_reactions (comment_id, user_id, kind)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		`
+	result, err := tx.ExecContext(ctx, query, commentID, userID, kind)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	delta += reactionScore(kind) * int32(rowsAffected)
+
+	err = adjustScore(ctx, tx, commentID, delta)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Take back a user's reaction to a comment
+func (c CommentModel) RemoveReaction(ctx context.Context, commentID int64, userID int64, kind string) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockComment(ctx, tx, commentID)
+	if err != nil {
+		return err
+	}
+
+	delta, err := deleteReaction(ctx, tx, commentID, userID, kind)
+	if err != nil {
+		return err
+	}
+	// the user had not reacted this way
+	if delta == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = adjustScore(ctx, tx, commentID, delta)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Fill in the Reactions of the comments, and of their replies, with
+// the number of reactions of each kind. Comments nobody reacted to
+// are left with a nil map
+func (c CommentModel) attachReactions(ctx context.Context, comments []*Comment) error {
+	byID := make(map[int64]*Comment)
+	var collect func(comments []*Comment)
+	collect = func(comments []*Comment) {
+		for _, comment := range comments {
+			byID[comment.ID] = comment
+			collect(comment.Replies)
+		}
+	}
+	collect(comments)
+	if len(byID) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(byID))
+	placeholders := make([]string, 0, len(byID))
+	for id := range byID {
+		args = append(args, id)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT comment_id, kind, COUNT(*)
+		FROM comment_reactions
+		WHERE comment_id IN (%s)
+		GROUP BY comment_id, kind`, strings.Join(placeholders, ", "))
+
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commentID int64
+		var kind string
+		var count int
+		err := rows.Scan(&commentID, &kind, &count)
+		if err != nil {
+			return err
+		}
+		comment := byID[commentID]
+		if comment.Reactions == nil {
+			comment.Reactions = make(map[string]int)
+		}
+		comment.Reactions[kind] = count
+	}
+
+	return rows.Err()
+}
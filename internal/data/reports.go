@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// The reasons a user can give for reporting a comment
+const (
+	ReportReasonSpam       = "spam"
+	ReportReasonAbuse      = "abuse"
+	ReportReasonHarassment = "harassment"
+	ReportReasonOffTopic   = "off_topic"
+	ReportReasonOther      = "other"
+)
+
+var ReportReasons = []string{ReportReasonSpam, ReportReasonAbuse, ReportReasonHarassment, ReportReasonOffTopic, ReportReasonOther}
+
+// A Report is a user telling the moderators that a comment is a problem.
+// It stays open until a moderator approves or rejects the comment
+type Report struct {
+	ID         int64      `json:"id"`
+	CommentID  int64      `json:"comment_id"`
+	ReporterID int64      `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func ValidateReport(v *validator.Validator, report *Report) {
+	v.Check(validator.PermittedValue(report.Reason, ReportReasons...), "reason", "must be one of "+strings.Join(ReportReasons, ", "))
+	// "other" tells the moderators nothing on its own
+	if report.Reason == ReportReasonOther {
+		v.Check(report.Note != "", "note", "must be provided when the reason is other")
+	}
+	v.Check(len(report.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+// Add a report to a comment. A user can only report a comment once. When
+// the comment reaches threshold open reports it is flagged, which hides
+// it until a moderator looks at it. A threshold of 0 never flags anything
+func (c CommentModel) AddReport(ctx context.Context, report *Report, threshold int) error {
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// also makes concurrent reports on the same comment take turns
+	// so that the count below is right
+	err = lockComment(ctx, tx, report.CommentID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO comment_reports (comment_id, reporter_id, reason, note)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		RETURNING id, created_at
+		`
+	args := []any{report.CommentID, report.ReporterID, report.Reason, report.Note}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	// no row comes back when the user already reported the comment
+	inserted := rows.Next()
+	if inserted {
+		err = rows.Scan(&report.ID, &report.CreatedAt)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		return ErrDuplicateReport
+	}
+
+	if threshold > 0 {
+		// only comments that are live get flagged, the others
+		// are hidden already
+		query = `
+			UPDATE comments
+			SET status = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 AND status = $3
+			AND (SELECT COUNT(*) FROM comment_reports
+			     WHERE comment_id = $2 AND resolved_at IS NULL) >= $4
+			`
+		args = []any{CommentStatusFlagged, report.CommentID, CommentStatusApproved, threshold}
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get the reports that no moderator has dealt with yet
+func (c CommentModel) GetOpenReports(ctx context.Context, filters Filters) ([]*Report, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, comment_id, reporter_id, reason, note, created_at, resolved_at
+		FROM comment_reports
+		WHERE resolved_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reports := []*Report{}
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(&totalRecords, &report.ID, &report.CommentID, &report.ReporterID,
+			&report.Reason, &report.Note, &report.CreatedAt, &report.ResolvedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reports = append(reports, &report)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return reports, metadata, nil
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A CommentRevision is the content a comment had at a specific version.
+// A row is written every time a comment is created or edited so there
+// is exactly one revision for every version of a comment
+type CommentRevision struct {
+	CommentID int64     `json:"comment_id"`
+	Version   int32     `json:"version"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Write the current content of the comment to the revision history.
+// It is called inside the transaction that writes the comment itself
+func insertRevision(ctx context.Context, tx *sql.Tx, comment *Comment) error {
+	query := `
+		INSERT INTO comment_revisions (comment_id, version, content, created_at)
+		VALUES ($1, $2, $3, $4)
+		`
+	args := []any{comment.ID, comment.Version, comment.Content, comment.UpdatedAt}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Get the revisions of a specific Comment
+func (c CommentModel) GetRevisions(ctx context.Context, commentID int64, filters Filters) ([]*CommentRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), comment_id, version, content, created_at
+		FROM comment_revisions
+		WHERE comment_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, commentID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*CommentRevision{}
+	for rows.Next() {
+		var revision CommentRevision
+		err := rows.Scan(&totalRecords, &revision.CommentID, &revision.Version,
+			&revision.Content, &revision.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Get a specific version of a Comment from the revision history
+func (c CommentModel) GetRevision(ctx context.Context, commentID int64, version int32) (*CommentRevision, error) {
+	if commentID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT comment_id, version, content, created_at
+		FROM comment_revisions
+		WHERE comment_id = $1 AND version = $2
+		`
+	var revision CommentRevision
+
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	err := c.DB.QueryRowContext(ctx, query, commentID, version).Scan(
+		&revision.CommentID, &revision.Version, &revision.Content, &revision.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
@@ -0,0 +1,40 @@
+package data
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// Check the thing a comment is about. Which types of target are allowed
+// is up to the server configuration, e.g. article and video
+func ValidateTarget(v *validator.Validator, targetType string, targetID string, permittedTypes []string) {
+	v.Check(targetType != "", "target_type", "must be provided")
+	if targetType != "" {
+		v.Check(validator.PermittedValue(targetType, permittedTypes...), "target_type", "must be one of "+strings.Join(permittedTypes, ", "))
+	}
+	v.Check(targetID != "", "target_id", "must be provided")
+	v.Check(len(targetID) <= 100, "target_id", "must not be more than 100 bytes long")
+}
+
+// Count the comments about a target that everybody can see, replies
+// included. This is the number a page shows next to its comments link
+func (c CommentModel) CountForTarget(ctx context.Context, targetType string, targetID string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM comments
+		WHERE target_type = $1 AND target_id = $2
+		AND deleted_at IS NULL AND status = $3
+		`
+	ctx, cancel := c.queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := c.DB.QueryRowContext(ctx, query, targetType, targetID, CommentStatusApproved).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryTokenStore keeps the tokens in memory, indexed by their hash
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]*Token
+}
+
+var _ TokenStore = (*MemoryTokenStore)(nil)
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]*Token),
+	}
+}
+
+func (m *MemoryTokenStore) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m *MemoryTokenStore) Insert(token *Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// we never keep the plaintext around
+	clone := *token
+	clone.Plaintext = ""
+	m.tokens[string(token.Hash)] = &clone
+
+	return nil
+}
+
+func (m *MemoryTokenStore) DeleteAllForUser(scope string, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// Find the token with the hash and scope, or nil if there is none
+func (m *MemoryTokenStore) find(hash []byte, scope string) *Token {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, found := m.tokens[string(hash)]
+	if !found || token.Scope != scope {
+		return nil
+	}
+
+	clone := *token
+	return &clone
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserStore keeps the user accounts in memory. It needs the
+// token store to find the owner of a token the way the JOIN in
+// UserModel.GetForToken does, and the permission store to give a
+// new user their permissions
+type MemoryUserStore struct {
+	mu          sync.RWMutex
+	nextID      int64
+	users       map[int64]*User
+	tokens      *MemoryTokenStore
+	permissions *MemoryPermissionStore
+}
+
+var _ UserStore = (*MemoryUserStore)(nil)
+
+func NewMemoryUserStore(tokens *MemoryTokenStore, permissions *MemoryPermissionStore) *MemoryUserStore {
+	return &MemoryUserStore{
+		users:       make(map[int64]*User),
+		tokens:      tokens,
+		permissions: permissions,
+	}
+}
+
+// Must be called with the lock held. Emails are case insensitive
+// just like the citext column
+func (m *MemoryUserStore) findByEmail(email string) *User {
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+func (m *MemoryUserStore) Insert(user *User, permissions ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findByEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	m.nextID++
+	user.ID = m.nextID
+	user.CreatedAt = memoryNow()
+	user.Version = 1
+
+	clone := *user
+	m.users[user.ID] = &clone
+
+	return m.permissions.AddForUser(user.ID, permissions...)
+}
+
+func (m *MemoryUserStore) Get(id int64) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, found := m.users[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	clone := *user
+	return &clone, nil
+}
+
+func (m *MemoryUserStore) GetByEmail(email string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user := m.findByEmail(email)
+	if user == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	clone := *user
+	return &clone, nil
+}
+
+func (m *MemoryUserStore) Update(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.users[user.ID]
+	if !found || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+	other := m.findByEmail(user.Email)
+	if other != nil && other.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	clone := *user
+	m.users[user.ID] = &clone
+
+	return nil
+}
+
+func (m *MemoryUserStore) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	token := m.tokens.find(tokenHash[:], tokenScope)
+	if token == nil || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, found := m.users[token.UserID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	clone := *user
+	return &clone, nil
+}
@@ -0,0 +1,143 @@
+// Package markdown turns the content of a comment into HTML that is safe
+// to put on a page. Only a small part of Markdown is supported: emphasis,
+// strikethrough, code, quotes, lists and links. Bare URLs become links and
+// @name becomes a mention. Whatever the Markdown renderer produces goes
+// through an allowlist sanitizer, so anything not on the list is dropped
+package markdown
+
+import (
+	"bytes"
+	"container/list"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Renderer renders comments and remembers the results. A comment's
+// content only changes together with its version, so id and version
+// are enough to find a rendering again
+type Renderer struct {
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+
+	mu      sync.Mutex
+	size    int
+	cache   map[cacheKey]*list.Element
+	recency *list.List
+}
+
+type cacheKey struct {
+	id      int64
+	version int32
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	content string
+	html    string
+}
+
+// Create a Renderer that keeps up to size renderings, dropping the
+// least recently used one when it is full. A size of 0 turns the cache off
+func New(size int) *Renderer {
+	return &Renderer{
+		markdown: goldmark.New(
+			// raw HTML in the content is left out by goldmark
+			// since we don't ask for the unsafe renderer
+			goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+			goldmark.WithParserOptions(
+				parser.WithInlineParsers(util.Prioritized(&mentionParser{}, 500)),
+			),
+			goldmark.WithRendererOptions(
+				renderer.WithNodeRenderers(util.Prioritized(&mentionRenderer{}, 500)),
+			),
+		),
+		policy:  newPolicy(),
+		size:    size,
+		cache:   make(map[cacheKey]*list.Element),
+		recency: list.New(),
+	}
+}
+
+// The tags and attributes a rendered comment may contain. Headings,
+// images and tables are not in the subset; their text is kept
+func newPolicy() *bluemonday.Policy {
+	policy := bluemonday.NewPolicy()
+	policy.AllowElements("p", "br", "em", "strong", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	policy.AllowAttrs("href").OnElements("a")
+	policy.AllowURLSchemes("http", "https", "mailto")
+	policy.RequireParseableURLs(true)
+	policy.RequireNoFollowOnLinks(true)
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("span")
+	return policy
+}
+
+// Render Markdown content to sanitized HTML without using the cache
+func (r *Renderer) Render(content string) (string, error) {
+	var buf bytes.Buffer
+	err := r.markdown.Convert([]byte(content), &buf)
+	if err != nil {
+		return "", err
+	}
+
+	return r.policy.Sanitize(buf.String()), nil
+}
+
+// Render the content of a comment, using the cached rendering for
+// this id and version if there is one. The content is kept next to
+// the rendering and compared as well, in case an id is ever reused
+func (r *Renderer) RenderComment(id int64, version int32, content string) (string, error) {
+	key := cacheKey{id: id, version: version}
+
+	r.mu.Lock()
+	element, found := r.cache[key]
+	if found {
+		entry := element.Value.(*cacheEntry)
+		if entry.content == content {
+			r.recency.MoveToFront(element)
+			r.mu.Unlock()
+			return entry.html, nil
+		}
+	}
+	r.mu.Unlock()
+
+	// render without holding the lock, two requests for the same
+	// comment at once just do the work twice
+	html, err := r.Render(content)
+	if err != nil {
+		return "", err
+	}
+
+	r.store(&cacheEntry{key: key, content: content, html: html})
+
+	return html, nil
+}
+
+func (r *Renderer) store(entry *cacheEntry) {
+	if r.size <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, found := r.cache[entry.key]
+	if found {
+		element.Value = entry
+		r.recency.MoveToFront(element)
+		return
+	}
+
+	r.cache[entry.key] = r.recency.PushFront(entry)
+	if r.recency.Len() > r.size {
+		oldest := r.recency.Back()
+		r.recency.Remove(oldest)
+		delete(r.cache, oldest.Value.(*cacheEntry).key)
+	}
+}
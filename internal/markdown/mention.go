@@ -0,0 +1,77 @@
+package markdown
+
+import (
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// A mention of another user, written as @name
+type mention struct {
+	ast.BaseInline
+	Name []byte
+}
+
+var kindMention = ast.NewNodeKind("Mention")
+
+func (n *mention) Kind() ast.NodeKind {
+	return kindMention
+}
+
+func (n *mention) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": string(n.Name)}, nil)
+}
+
+// mentionParser picks up @name when the @ starts a word, so the
+// middle of an email address is not taken for a mention
+type mentionParser struct{}
+
+func (p *mentionParser) Trigger() []byte {
+	return []byte{'@'}
+}
+
+func (p *mentionParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	if unicode.IsLetter(before) || unicode.IsDigit(before) || before == '_' {
+		return nil
+	}
+
+	line, _ := block.PeekLine()
+	end := 1
+	for end < len(line) && isNameByte(line[end]) {
+		end++
+	}
+	// "thanks @ann." ends the sentence, the dot is not part of the name
+	for end > 1 && (line[end-1] == '.' || line[end-1] == '-') {
+		end--
+	}
+	if end == 1 {
+		return nil
+	}
+
+	block.Advance(end)
+	return &mention{Name: line[1:end]}
+}
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '.' || b == '-'
+}
+
+type mentionRenderer struct{}
+
+func (r *mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMention, r.renderMention)
+}
+
+func (r *mentionRenderer) renderMention(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString(`<span class="mention">@`)
+		_, _ = w.Write(util.EscapeHTML(node.(*mention).Name))
+		_, _ = w.WriteString(`</span>`)
+	}
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,336 @@
+// Package migrate applies the SQL migrations embedded in the binary. It
+// keeps track of the schema version in the same schema_migrations table
+// the migrate CLI uses, so a database set up with one can be managed
+// with the other
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+var (
+	ErrDirty          = errors.New("the database is marked dirty, a migration failed half way and has to be fixed by hand")
+	ErrMissingVersion = errors.New("there is no migration for the version the database is at")
+)
+
+// The key of the PostgreSQL advisory lock that step holds while it
+// migrates, any number only this package uses will do
+const advisoryLockID = 7043815320954113
+
+// Migration files are named like 000001_create_comments_table.up.sql
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// A Migration is one version of the schema, made of an up file that
+// moves the database to it and a down file that undoes it again
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+type Migrator struct {
+	DB *sql.DB
+	// the .sql files, as returned by migrations.Files()
+	Files fs.FS
+	// postgres or sqlite
+	Backend string
+}
+
+// Get all the migrations in version order
+func (m Migrator) Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(m.Files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := filenameRX.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %s: %w", entry.Name(), err)
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if match[3] == "up" {
+			migration.up = entry.Name()
+		} else {
+			migration.down = entry.Name()
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return migrations, nil
+}
+
+// Latest is the version the schema is at once every migration is applied
+func (m Migrator) Latest() (int64, error) {
+	migrations, err := m.Migrations()
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// Create the schema_migrations table if this is a new database. It has
+// at most one row, holding the current version, and no row at all
+// while no migration has been applied. It runs in the transaction of
+// step once that holds the migration lock: two copies of the API
+// creating the table at the same time could otherwise both try and
+// one of them fail
+func ensureTable(ctx context.Context, tx *sql.Tx) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`
+	_, err := tx.ExecContext(ctx, query)
+	return err
+}
+
+// Report whether the schema_migrations table is there, without
+// creating it the way step does
+func (m Migrator) tableExists(ctx context.Context) (bool, error) {
+	query := `SELECT to_regclass('schema_migrations') IS NOT NULL`
+	if m.Backend == "sqlite" {
+		query = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`
+	}
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query).Scan(&exists)
+	return exists, err
+}
+
+// Get the version the database is at and if the last migration
+// was left half done. It only reads, a database without the
+// schema_migrations table is at version 0
+func (m Migrator) Version() (int64, bool, error) {
+	return m.VersionContext(context.Background())
+}
+
+// VersionContext is Version with a context, for callers such as the
+// readiness check that would rather give up than wait on the database
+func (m Migrator) VersionContext(ctx context.Context) (int64, bool, error) {
+	exists, err := m.tableExists(ctx)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	return readVersion(ctx, m.DB)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func readVersion(ctx context.Context, db queryer) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// Apply every migration that is newer than the database. It returns
+// how many were applied
+func (m Migrator) Up() (int, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for {
+		done, err := m.step(func(current int64) (string, int64, error) {
+			for _, migration := range migrations {
+				if migration.Version > current {
+					return migration.file(migration.up), migration.Version, nil
+				}
+			}
+			return "", 0, nil
+		})
+		if err != nil || done {
+			return applied, err
+		}
+		applied++
+	}
+}
+
+// Undo the last steps migrations. It returns how many were undone,
+// which is less than steps if we got back to an empty database
+func (m Migrator) Down(steps int) (int, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	undone := 0
+	for undone < steps {
+		done, err := m.step(func(current int64) (string, int64, error) {
+			if current == 0 {
+				return "", 0, nil
+			}
+			i := slices.IndexFunc(migrations, func(migration Migration) bool {
+				return migration.Version == current
+			})
+			if i < 0 {
+				return "", 0, ErrMissingVersion
+			}
+			// the database goes back to the migration before this one
+			previous := int64(0)
+			if i > 0 {
+				previous = migrations[i-1].Version
+			}
+			return migrations[i].file(migrations[i].down), previous, nil
+		})
+		if err != nil || done {
+			return undone, err
+		}
+		undone++
+	}
+
+	return undone, nil
+}
+
+// The name of one of the files of a migration. A missing file gets a
+// name that cannot be opened so the error says what we looked for
+func (m Migration) file(name string) string {
+	if name == "" {
+		return fmt.Sprintf("%06d_%s (missing)", m.Version, m.Name)
+	}
+	return name
+}
+
+// Run a single migration in its own transaction. next is given the current
+// version and returns the file to run and the version the database is at
+// afterwards, or an empty file name when there is nothing left to do.
+//
+// The migration lock is held for the whole transaction so that
+// several copies of the API starting at the same time do not apply the
+// same migration twice: the others wait and then see the new version
+func (m Migrator) step(next func(current int64) (string, int64, error)) (bool, error) {
+	// migrations can take as long as they need
+	ctx := context.Background()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// the table may not exist yet so there is nothing to lock, PostgreSQL
+	// takes an advisory lock instead that is released when the
+	// transaction ends. SQLite has no locks of its own, but the first write
+	// takes the database lock and holds it until the transaction ends
+	if m.Backend != "sqlite" {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, advisoryLockID)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = ensureTable(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if m.Backend == "sqlite" {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE FALSE`)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	current, dirty, err := readVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if dirty {
+		return false, ErrDirty
+	}
+
+	file, version, err := next(current)
+	if err != nil {
+		return false, err
+	}
+	if file == "" {
+		return true, tx.Commit()
+	}
+
+	script, err := fs.ReadFile(m.Files, file)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, string(script))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", file, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return false, err
+	}
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE)`, version)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, tx.Commit()
+}
+
+// A Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Get every migration and whether the database has it
+func (m Migrator) Status() ([]Status, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	current, _, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		status = append(status, Status{Migration: migration, Applied: migration.Version <= current})
+	}
+
+	return status, nil
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// One entry of the config file. Which fields matter depends on the rule:
+//
+//	[
+//	  {"rule": "banned_words", "action": "mask", "file": "banned.txt"},
+//	  {"rule": "max_links", "action": "reject", "max": 3},
+//	  {"rule": "repeated_characters", "action": "mask", "max": 10},
+//	  {"rule": "all_caps", "action": "flag", "min_letters": 20, "max_ratio": 0.8},
+//	  {"rule": "duplicate_content", "action": "reject", "window": "2m"}
+//	]
+//
+// The rules run in the order they are listed in
+type ruleConfig struct {
+	Rule       string  `json:"rule"`
+	Action     string  `json:"action"`
+	File       string  `json:"file"`
+	Max        int     `json:"max"`
+	MinLetters int     `json:"min_letters"`
+	MaxRatio   float64 `json:"max_ratio"`
+	Window     string  `json:"window"`
+}
+
+// The rules used when no config file is given. They only stop the
+// obvious abuse: link spam, keyboard mashing and double submits
+func Default(finder DuplicateFinder) *Pipeline {
+	return &Pipeline{
+		Rules: []Rule{
+			&MaxLinks{Max: 3, Action: Reject},
+			&RepeatedCharacters{Max: 10, Action: Mask},
+			&DuplicateContent{Window: 2 * time.Minute, Action: Reject, Finder: finder},
+		},
+	}
+}
+
+// Read the rules from a JSON config file. Relative paths to word lists
+// are taken from where the server was started
+func Load(path string, finder DuplicateFinder) (*Pipeline, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []ruleConfig
+	err = json.Unmarshal(contents, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("content policy %s: %w", path, err)
+	}
+
+	pipeline := &Pipeline{}
+	for i, config := range configs {
+		rule, err := newRule(config, finder)
+		if err != nil {
+			return nil, fmt.Errorf("content policy %s: rule %d: %w", path, i+1, err)
+		}
+		pipeline.Rules = append(pipeline.Rules, rule)
+	}
+
+	return pipeline, nil
+}
+
+func newRule(config ruleConfig, finder DuplicateFinder) (Rule, error) {
+	action, err := ParseAction(config.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Rule {
+	case "banned_words":
+		if config.File == "" {
+			return nil, errors.New("banned_words needs a file")
+		}
+		return LoadBannedWords(config.File, action)
+	case "max_links":
+		if config.Max < 0 {
+			return nil, errors.New("max_links needs a max of 0 or more")
+		}
+		return &MaxLinks{Max: config.Max, Action: action}, nil
+	case "repeated_characters":
+		if config.Max < 1 {
+			return nil, errors.New("repeated_characters needs a max of 1 or more")
+		}
+		return &RepeatedCharacters{Max: config.Max, Action: action}, nil
+	case "all_caps":
+		if config.MaxRatio <= 0 || config.MaxRatio >= 1 {
+			return nil, errors.New("all_caps needs a max_ratio between 0 and 1")
+		}
+		return &AllCaps{MinLetters: config.MinLetters, MaxRatio: config.MaxRatio, Action: action}, nil
+	case "duplicate_content":
+		if action == Mask {
+			return nil, errors.New("duplicate_content can only flag or reject")
+		}
+		window, err := time.ParseDuration(config.Window)
+		if err != nil || window <= 0 {
+			return nil, errors.New("duplicate_content needs a window like \"2m\"")
+		}
+		return &DuplicateContent{Window: window, Action: action, Finder: finder}, nil
+	default:
+		return nil, fmt.Errorf("unknown rule %q", config.Rule)
+	}
+}
@@ -0,0 +1,99 @@
+// Package policy decides what happens to the content of a comment before
+// it is stored. A Pipeline runs an ordered list of rules, and each rule
+// can let the content through, mask the offending parts, flag the comment
+// for a moderator or reject it outright
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// What a rule wants done with a comment
+type Action int
+
+const (
+	Allow Action = iota
+	Mask
+	Flag
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Allow:
+		return "allow"
+	case Mask:
+		return "mask"
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	default:
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+}
+
+// Turn the action name used in the config file into an Action
+func ParseAction(name string) (Action, error) {
+	switch name {
+	case "mask":
+		return Mask, nil
+	case "flag":
+		return Flag, nil
+	case "reject":
+		return Reject, nil
+	default:
+		return Allow, fmt.Errorf("unknown action %q (must be mask, flag or reject)", name)
+	}
+}
+
+// A Verdict is what a rule decided. Message explains a Reject or Flag
+// and Content holds the masked text for a Mask
+type Verdict struct {
+	Action  Action
+	Message string
+	Content string
+}
+
+// A Rule looks at one thing in a comment. Rules only see the comment
+// they are given so each one can be tried out on its own
+type Rule interface {
+	Name() string
+	Check(ctx context.Context, comment *data.Comment) (Verdict, error)
+}
+
+type Pipeline struct {
+	Rules []Rule
+}
+
+// Run the rules in order on the comment. Masking rules change
+// comment.Content so the rules after them see the masked text. The
+// first rule that rejects the comment adds a "content" error to v and
+// stops the pipeline. The names of the rules that flagged the comment
+// are returned so the caller can hold it back for a moderator
+func (p *Pipeline) Apply(ctx context.Context, comment *data.Comment, v *validator.Validator) ([]string, error) {
+	var flagged []string
+
+	for _, rule := range p.Rules {
+		verdict, err := rule.Check(ctx, comment)
+		if err != nil {
+			return nil, fmt.Errorf("content policy rule %s: %w", rule.Name(), err)
+		}
+
+		switch verdict.Action {
+		case Mask:
+			comment.Content = verdict.Content
+		case Flag:
+			flagged = append(flagged, rule.Name())
+		case Reject:
+			v.AddError("content", verdict.Message)
+			return flagged, nil
+		}
+	}
+
+	return flagged, nil
+}
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/tchenbz/comments/internal/data"
+	"github.com/tchenbz/comments/internal/validator"
+)
+
+// A rule that gives the same verdict every time and records the
+// content it saw
+type stubRule struct {
+	name    string
+	verdict Verdict
+	err     error
+	seen    []string
+}
+
+func (s *stubRule) Name() string {
+	return s.name
+}
+
+func (s *stubRule) Check(ctx context.Context, comment *data.Comment) (Verdict, error) {
+	s.seen = append(s.seen, comment.Content)
+	return s.verdict, s.err
+}
+
+func TestPipelineApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []*stubRule
+		content string
+		flagged []string
+		errors  map[string]string
+		// the content each rule saw, nil if it never ran
+		seen [][]string
+	}{
+		{
+			name:    "all allow",
+			rules:   []*stubRule{{name: "a"}, {name: "b"}},
+			content: "hello",
+			seen:    [][]string{{"hello"}, {"hello"}},
+		},
+		{
+			name: "mask feeds the next rule",
+			rules: []*stubRule{
+				{name: "a", verdict: Verdict{Action: Mask, Content: "h***o"}},
+				{name: "b"},
+			},
+			content: "h***o",
+			seen:    [][]string{{"hello"}, {"h***o"}},
+		},
+		{
+			name: "flags are collected",
+			rules: []*stubRule{
+				{name: "a", verdict: Verdict{Action: Flag, Message: "looks odd"}},
+				{name: "b"},
+				{name: "c", verdict: Verdict{Action: Flag, Message: "looks odd too"}},
+			},
+			content: "hello",
+			flagged: []string{"a", "c"},
+			seen:    [][]string{{"hello"}, {"hello"}, {"hello"}},
+		},
+		{
+			name: "reject stops the pipeline",
+			rules: []*stubRule{
+				{name: "a", verdict: Verdict{Action: Flag, Message: "looks odd"}},
+				{name: "b", verdict: Verdict{Action: Reject, Message: "not allowed"}},
+				{name: "c"},
+			},
+			content: "hello",
+			flagged: []string{"a"},
+			errors:  map[string]string{"content": "not allowed"},
+			seen:    [][]string{{"hello"}, {"hello"}, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := &Pipeline{}
+			for _, rule := range tt.rules {
+				pipeline.Rules = append(pipeline.Rules, rule)
+			}
+			comment := &data.Comment{Content: "hello"}
+			v := validator.New()
+
+			flagged, err := pipeline.Apply(context.Background(), comment, v)
+			if err != nil {
+				t.Fatalf("Apply returned %v", err)
+			}
+			if !slices.Equal(flagged, tt.flagged) {
+				t.Errorf("got flagged %v, want %v", flagged, tt.flagged)
+			}
+			if comment.Content != tt.content {
+				t.Errorf("got content %q, want %q", comment.Content, tt.content)
+			}
+			if len(v.Errors) != len(tt.errors) {
+				t.Errorf("got errors %v, want %v", v.Errors, tt.errors)
+			}
+			for key, message := range tt.errors {
+				if v.Errors[key] != message {
+					t.Errorf("got %s error %q, want %q", key, v.Errors[key], message)
+				}
+			}
+			for i, rule := range tt.rules {
+				if !slices.Equal(rule.seen, tt.seen[i]) {
+					t.Errorf("rule %s saw %q, want %q", rule.name, rule.seen, tt.seen[i])
+				}
+			}
+		})
+	}
+
+	t.Run("rule error", func(t *testing.T) {
+		errBroken := errors.New("broken")
+		pipeline := &Pipeline{Rules: []Rule{&stubRule{name: "a", err: errBroken}}}
+
+		_, err := pipeline.Apply(context.Background(), &data.Comment{Content: "hello"}, validator.New())
+		if !errors.Is(err, errBroken) {
+			t.Errorf("got error %v, want %v", err, errBroken)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tchenbz/comments/internal/data"
+)
+
+// BannedWords catches words from a list, ignoring case. Masking replaces
+// every letter of a banned word with a *
+type BannedWords struct {
+	Action  Action
+	pattern *regexp.Regexp
+}
+
+func NewBannedWords(words []string, action Action) *BannedWords {
+	rule := &BannedWords{Action: action}
+
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	// \b only knows ASCII letters, so the word boundaries are spelled
+	// out with the Unicode classes and the word itself is group 1
+	if len(quoted) > 0 {
+		rule.pattern = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}_])`)
+	}
+
+	return rule
+}
+
+// Find where the banned words are in content. The boundary after a
+// word is part of the match, so each search starts again at the end
+// of the word to catch the next one in "darn darn"
+func (b *BannedWords) find(content string) [][2]int {
+	var found [][2]int
+	for start := 0; start < len(content); {
+		loc := b.pattern.FindStringSubmatchIndex(content[start:])
+		if loc == nil {
+			break
+		}
+		found = append(found, [2]int{start + loc[2], start + loc[3]})
+		start += loc[3]
+	}
+
+	return found
+}
+
+// Read the banned words from a file with one word per line. Blank
+// lines and lines starting with # are skipped
+func LoadBannedWords(path string, action Action) (*BannedWords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBannedWords(words, action), nil
+}
+
+func (b *BannedWords) Name() string {
+	return "banned_words"
+}
+
+func (b *BannedWords) Check(ctx context.Context, comment *data.Comment) (Verdict, error) {
+	if b.pattern == nil {
+		return Verdict{Action: Allow}, nil
+	}
+	found := b.find(comment.Content)
+	if len(found) == 0 {
+		return Verdict{Action: Allow}, nil
+	}
+
+	var masked strings.Builder
+	last := 0
+	for _, word := range found {
+		masked.WriteString(comment.Content[last:word[0]])
+		masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(comment.Content[word[0]:word[1]])))
+		last = word[1]
+	}
+	masked.WriteString(comment.Content[last:])
+
+	return Verdict{
+		Action:  b.Action,
+		Message: "must not contain banned words",
+		Content: masked.String(),
+	}, nil
+}
+
+var linkRX = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// MaxLinks limits how many links a comment can have. Masking keeps
+// the first Max links and replaces the others with [link]
+type MaxLinks struct {
+	Max    int
+	Action Action
+}
+
+func (m *MaxLinks) Name() string {
+	return "max_links"
+}
+
+func (m *MaxLinks) Check(ctx context.Context, comment *data.Comment) (Verdict, error) {
+	links := linkRX.FindAllStringIndex(comment.Content, -1)
+	if len(links) <= m.Max {
+		return Verdict{Action: Allow}, nil
+	}
+
+	seen := 0
+	masked := linkRX.ReplaceAllStringFunc(comment.Content, func(link string) string {
+		seen++
+		if seen <= m.Max {
+			return link
+		}
+		return "[link]"
+	})
+
+	return Verdict{
+		Action:  m.Action,
+		Message: fmt.Sprintf("must not contain more than %d links", m.Max),
+		Content: masked,
+	}, nil
+}
+
+// RepeatedCharacters catches the same character typed more than Max
+// times in a row, like "nooooooooo" or "!!!!!!!!!!". Masking shortens
+// each run to Max characters
+type RepeatedCharacters struct {
+	Max    int
+	Action Action
+}
+
+func (rc *RepeatedCharacters) Name() string {
+	return "repeated_characters"
+}
+
+func (rc *RepeatedCharacters) Check(ctx context.Context, comment *data.Comment) (Verdict, error) {
+	var masked strings.Builder
+	found := false
+
+	var previous rune
+	run := 0
+	for _, r := range comment.Content {
+		if r == previous {
+			run++
+		} else {
+			previous = r
+			run = 1
+		}
+		if run > rc.Max {
+			found = true
+			continue
+		}
+		masked.WriteRune(r)
+	}
+
+	if !found {
+		return Verdict{Action: Allow}, nil
+	}
+
+	return Verdict{
+		Action:  rc.Action,
+		Message: fmt.Sprintf("must not repeat a character more than %d times in a row", rc.Max),
+		Content: masked.String(),
+	}, nil
+}
+
+// AllCaps catches comments written (almost) entirely in capital letters.
+// Comments with fewer than MinLetters letters are left alone so that
+// short ones like "OK" or "LOL" get through. Masking lowercases the comment
+type AllCaps struct {
+	MinLetters int
+	// the share of the letters that can be capitals, between 0 and 1
+	MaxRatio float64
+	Action   Action
+}
+
+func (ac *AllCaps) Name() string {
+	return "all_caps"
+}
+
+func (ac *AllCaps) Check(ctx context.Context, comment *data.Comment) (Verdict, error) {
+	letters, capitals := 0, 0
+	for _, r := range comment.Content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				capitals++
+			}
+		}
+	}
+
+	// a comment without letters (e.g. "+1") has no case at all, and
+	// would otherwise divide by zero when MinLetters is left at 0
+	if letters == 0 || letters < ac.MinLetters || float64(capitals)/float64(letters) <= ac.MaxRatio {
+		return Verdict{Action: Allow}, nil
+	}
+
+	return Verdict{
+		Action:  ac.Action,
+		Message: "must not be written in capital letters",
+		Content: strings.ToLower(comment.Content),
+	}, nil
+}
+
+// DuplicateFinder is the part of data.CommentStore that DuplicateContent
+// needs, so the rule can be tried out without a database
+type DuplicateFinder interface {
+	HasRecentDuplicate(ctx context.Context, authorID int64, content string, since time.Time, excludeID int64) (bool, error)
+}
+
+// DuplicateContent catches an author posting the same thing again
+// within Window, most often a double submit. There is nothing to mask
+// so it can only flag or reject
+type DuplicateContent struct {
+	Window time.Duration
+	Action Action
+	Finder DuplicateFinder
+}
+
+func (d *DuplicateContent) Name() string {
+	return "duplicate_content"
+}
+
+func (d *DuplicateContent) Check(ctx context.Context, comment *data.Comment) (Verdict, error) {
+	since := time.Now().Add(-d.Window)
+	duplicate, err := d.Finder.HasRecentDuplicate(ctx, comment.Author.ID, comment.Content, since, comment.ID)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if !duplicate {
+		return Verdict{Action: Allow}, nil
+	}
+
+	return Verdict{
+		Action:  d.Action,
+		Message: fmt.Sprintf("is the same as a comment you posted in the last %s", d.Window),
+	}, nil
+}
@@ -0,0 +1,194 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tchenbz/comments/internal/data"
+)
+
+// Each case runs one rule on one comment and checks the verdict. Content
+// is only compared when the rule masks
+type ruleTest struct {
+	name    string
+	content string
+	action  Action
+	masked  string
+}
+
+func runRuleTests(t *testing.T, rule Rule, tests []ruleTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment := &data.Comment{Content: tt.content}
+			verdict, err := rule.Check(context.Background(), comment)
+			if err != nil {
+				t.Fatalf("Check returned %v", err)
+			}
+			if verdict.Action != tt.action {
+				t.Fatalf("got action %s, want %s", verdict.Action, tt.action)
+			}
+			if tt.action == Mask && verdict.Content != tt.masked {
+				t.Errorf("got content %q, want %q", verdict.Content, tt.masked)
+			}
+			if tt.action != Allow && verdict.Message == "" {
+				t.Errorf("got no message for a %s verdict", verdict.Action)
+			}
+		})
+	}
+}
+
+func TestBannedWords(t *testing.T) {
+	rule := NewBannedWords([]string{"darn", "heck", "ärger", ""}, Mask)
+
+	runRuleTests(t, rule, []ruleTest{
+		{name: "clean", content: "what a lovely day", action: Allow},
+		{name: "banned word", content: "darn it", action: Mask, masked: "**** it"},
+		{name: "ignores case", content: "Oh HECK no", action: Mask, masked: "Oh **** no"},
+		{name: "whole words only", content: "darning socks", action: Allow},
+		{name: "every match", content: "darn, heck, darn", action: Mask, masked: "****, ****, ****"},
+		{name: "next to each other", content: "darn darn", action: Mask, masked: "**** ****"},
+		{name: "non-ASCII word", content: "So ein ÄRGER!", action: Mask, masked: "So ein *****!"},
+		{name: "non-ASCII whole words only", content: "Ärgernis and überdarn", action: Allow},
+	})
+
+	t.Run("empty list", func(t *testing.T) {
+		runRuleTests(t, NewBannedWords(nil, Reject), []ruleTest{
+			{name: "anything goes", content: "darn", action: Allow},
+		})
+	})
+}
+
+func TestLoadBannedWords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	err := os.WriteFile(path, []byte("# words we don't want\ndarn\n\n  heck  \n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := LoadBannedWords(path, Reject)
+	if err != nil {
+		t.Fatalf("LoadBannedWords returned %v", err)
+	}
+
+	runRuleTests(t, rule, []ruleTest{
+		{name: "listed word", content: "heck", action: Reject},
+		{name: "comment line", content: "words we don't want", action: Allow},
+	})
+}
+
+func TestMaxLinks(t *testing.T) {
+	rule := &MaxLinks{Max: 2, Action: Mask}
+
+	runRuleTests(t, rule, []ruleTest{
+		{name: "no links", content: "nothing to see", action: Allow},
+		{name: "at the limit", content: "https://a.example and www.b.example", action: Allow},
+		{
+			name:    "over the limit",
+			content: "http://a.example https://b.example www.c.example HTTPS://d.example",
+			action:  Mask,
+			masked:  "http://a.example https://b.example [link] [link]",
+		},
+	})
+
+	t.Run("no links allowed", func(t *testing.T) {
+		runRuleTests(t, &MaxLinks{Max: 0, Action: Reject}, []ruleTest{
+			{name: "one link", content: "see www.a.example", action: Reject},
+		})
+	})
+}
+
+func TestRepeatedCharacters(t *testing.T) {
+	rule := &RepeatedCharacters{Max: 3, Action: Mask}
+
+	runRuleTests(t, rule, []ruleTest{
+		{name: "no runs", content: "hello there", action: Allow},
+		{name: "at the limit", content: "nooo", action: Allow},
+		{name: "over the limit", content: "noooooo!!!!!", action: Mask, masked: "nooo!!!"},
+		{name: "runes", content: "ééééé", action: Mask, masked: "ééé"},
+	})
+}
+
+func TestAllCaps(t *testing.T) {
+	rule := &AllCaps{MinLetters: 5, MaxRatio: 0.8, Action: Mask}
+
+	runRuleTests(t, rule, []ruleTest{
+		{name: "lower case", content: "this is fine", action: Allow},
+		{name: "too short", content: "LOL", action: Allow},
+		{name: "shouting", content: "STOP SHOUTING", action: Mask, masked: "stop shouting"},
+		{name: "under the ratio", content: "NASA and ESA", action: Allow},
+		{name: "no letters", content: "+1 123", action: Allow},
+	})
+
+	// without a MinLetters a comment with no letters used to divide
+	// by zero and get caught
+	t.Run("no minimum", func(t *testing.T) {
+		runRuleTests(t, &AllCaps{MaxRatio: 0.5, Action: Reject}, []ruleTest{
+			{name: "no letters", content: "!!! 42", action: Allow},
+			{name: "shouting", content: "NO", action: Reject},
+		})
+	})
+}
+
+// Answers HasRecentDuplicate from a fixed result and remembers
+// what it was asked
+type fakeFinder struct {
+	duplicate bool
+	err       error
+	authorID  int64
+	since     time.Time
+	excludeID int64
+}
+
+func (f *fakeFinder) HasRecentDuplicate(ctx context.Context, authorID int64, content string, since time.Time, excludeID int64) (bool, error) {
+	f.authorID, f.since, f.excludeID = authorID, since, excludeID
+	return f.duplicate, f.err
+}
+
+func TestDuplicateContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		duplicate bool
+		action    Action
+	}{
+		{name: "new content", duplicate: false, action: Allow},
+		{name: "duplicate", duplicate: true, action: Flag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder := &fakeFinder{duplicate: tt.duplicate}
+			rule := &DuplicateContent{Window: time.Minute, Action: Flag, Finder: finder}
+			comment := &data.Comment{ID: 7, Content: "same again", Author: data.CommentAuthor{ID: 3}}
+
+			before := time.Now()
+			verdict, err := rule.Check(context.Background(), comment)
+			if err != nil {
+				t.Fatalf("Check returned %v", err)
+			}
+			if verdict.Action != tt.action {
+				t.Errorf("got action %s, want %s", verdict.Action, tt.action)
+			}
+			if finder.authorID != 3 || finder.excludeID != 7 {
+				t.Errorf("looked for author %d excluding %d, want author 3 excluding 7", finder.authorID, finder.excludeID)
+			}
+			if finder.since.Before(before.Add(-time.Minute)) {
+				t.Errorf("looked back to %s, want about a minute before %s", finder.since, before)
+			}
+		})
+	}
+
+	t.Run("finder error", func(t *testing.T) {
+		errBroken := errors.New("broken")
+		rule := &DuplicateContent{Window: time.Minute, Action: Reject, Finder: &fakeFinder{err: errBroken}}
+		_, err := rule.Check(context.Background(), &data.Comment{Content: "hi"})
+		if !errors.Is(err, errBroken) {
+			t.Errorf("got error %v, want %v", err, errBroken)
+		}
+	})
+}
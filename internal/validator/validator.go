@@ -1,6 +1,7 @@
 package validator
 
 import (
+    "regexp"
     "slices"
   )
 
@@ -45,3 +46,11 @@ func PermittedValue(value string, permittedValues ...string) bool {
     return slices.Contains(permittedValues, value)  
 }
 
+
+// A regular expression for checking the format of email addresses
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Check if a string value matches a specific regexp pattern
+func Matches(value string, rx *regexp.Regexp) bool {
+    return rx.MatchString(value)
+}
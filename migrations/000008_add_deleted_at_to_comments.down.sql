@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS comments_deleted_at_idx;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted_at;
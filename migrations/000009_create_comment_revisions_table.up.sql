@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    version integer NOT NULL,
+    content text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (comment_id, version)
+);
+
+-- the earlier versions of existing comments are gone, but we
+-- can at least record the version they are at now
+INSERT INTO comment_revisions (comment_id, version, content, created_at)
+SELECT id, version, content, updated_at FROM comments
+ON CONFLICT DO NOTHING;
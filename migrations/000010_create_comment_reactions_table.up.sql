@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS comment_reactions (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (comment_id, user_id, kind)
+);
+
+-- the score is kept on the comment so that sorting by it can use an index
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS score integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS comments_score_idx ON comments(score, id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS comment_moderations;
+
+DROP INDEX IF EXISTS comments_status_idx;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS status;
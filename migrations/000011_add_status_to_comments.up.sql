@@ -0,0 +1,15 @@
+-- comments written before moderation existed were already live
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved';
+
+CREATE INDEX IF NOT EXISTS comments_status_idx ON comments(status) WHERE status <> 'approved';
+
+CREATE TABLE IF NOT EXISTS comment_moderations (
+    id bigserial PRIMARY KEY,
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    moderator_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS comment_moderations_comment_id_idx ON comment_moderations(comment_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS comment_reports (
+    id bigserial PRIMARY KEY,
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    reporter_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    note text NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) WITH TIME ZONE,
+    UNIQUE (comment_id, reporter_id)
+);
+
+CREATE INDEX IF NOT EXISTS comment_reports_open_idx ON comment_reports(id) WHERE resolved_at IS NULL;
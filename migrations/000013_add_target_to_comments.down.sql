@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS comments_target_idx;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS target_id;
+ALTER TABLE comments DROP COLUMN IF EXISTS target_type;
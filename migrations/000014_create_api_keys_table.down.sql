@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS api_keys_permissions;
+DROP TABLE IF EXISTS api_keys;
+
+DELETE FROM permissions WHERE code = 'api_keys:manage';
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    hash bytea NOT NULL UNIQUE,
+    name text NOT NULL,
+    -- the account the service acts as, e.g. the author of its comments
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rps double precision NOT NULL,
+    burst integer NOT NULL,
+    revoked_at timestamp(0) WITH TIME ZONE
+);
+
+-- the scopes of a key, a key can only use the permissions listed here
+CREATE TABLE IF NOT EXISTS api_keys_permissions (
+    api_key_id bigint NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('api_keys:manage');
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS users_delete_comments_author ON users;
+DROP FUNCTION IF EXISTS comments_forget_deleted_user();
+
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_user_id_fkey;
+ALTER TABLE comments ADD CONSTRAINT comments_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
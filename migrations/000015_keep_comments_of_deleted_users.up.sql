@@ -0,0 +1,18 @@
+-- deleting a user used to delete their comments and every reply below
+-- them, the comments now stay without an author
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_user_id_fkey;
+ALTER TABLE comments ADD CONSTRAINT comments_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users ON DELETE SET NULL;
+
+-- comments written before 000005 have no user_id either but still show
+-- who wrote them, the name goes with the user so the two can be told apart
+CREATE OR REPLACE FUNCTION comments_forget_deleted_user() RETURNS trigger AS $$
+BEGIN
+    UPDATE comments SET author = '[deleted]', updated_at = NOW() WHERE user_id = OLD.id;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_delete_comments_author ON users;
+CREATE TRIGGER users_delete_comments_author BEFORE DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION comments_forget_deleted_user();
@@ -0,0 +1 @@
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
@@ -0,0 +1,3 @@
+-- the code is how the API looks a permission up, so there must only be
+-- one row for each
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
@@ -0,0 +1,35 @@
+// Package migrations embeds the SQL migrations into the binary so the
+// API can bring its own database schema up to date
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The PostgreSQL migrations live in this directory and the
+// SQLite ones in sqlite/
+//
+//go:embed *.sql
+var postgres embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// Files returns the migrations for a storage backend (postgres or
+// sqlite), or nil if the backend does not use migrations
+func Files(backend string) fs.FS {
+	switch backend {
+	case "postgres":
+		return postgres
+	case "sqlite":
+		files, err := fs.Sub(sqlite, "sqlite")
+		if err != nil {
+			// the directory is embedded so this cannot happen
+			panic(err)
+		}
+		return files
+	default:
+		return nil
+	}
+}
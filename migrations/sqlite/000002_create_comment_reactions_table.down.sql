@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS comments_score_idx;
+
+ALTER TABLE comments DROP COLUMN score;
+
+DROP TABLE IF EXISTS comment_reactions;
@@ -0,0 +1,15 @@
+-- comments written before moderation existed were already live
+ALTER TABLE comments ADD COLUMN status TEXT NOT NULL DEFAULT 'approved';
+
+CREATE INDEX IF NOT EXISTS comments_status_idx ON comments(status) WHERE status <> 'approved';
+
+CREATE TABLE IF NOT EXISTS comment_moderations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    comment_id INTEGER NOT NULL REFERENCES comments ON DELETE CASCADE,
+    moderator_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS comment_moderations_comment_id_idx ON comment_moderations(comment_id);
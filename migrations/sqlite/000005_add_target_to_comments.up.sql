@@ -0,0 +1,6 @@
+-- comments written before targets existed were about the one page
+-- the deployment served, they keep an empty target until moved
+ALTER TABLE comments ADD COLUMN target_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN target_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS comments_target_idx ON comments(target_type, target_id, id);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    hash BLOB NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    -- the account the service acts as, e.g. the author of its comments
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    rps REAL NOT NULL,
+    burst INTEGER NOT NULL,
+    revoked_at DATETIME
+);
+
+-- the scopes of a key, a key can only use the permissions listed here
+CREATE TABLE IF NOT EXISTS api_keys_permissions (
+    api_key_id INTEGER NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('api_keys:manage');
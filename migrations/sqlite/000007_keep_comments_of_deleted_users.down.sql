@@ -0,0 +1 @@
+DROP TRIGGER IF EXISTS users_delete_comments_author;
@@ -0,0 +1,9 @@
+-- deleting a user used to delete their comments and every reply below
+-- them. SQLite cannot change the foreign key of comments.user_id without
+-- rebuilding the table, so this unlinks the comments before the cascade
+-- gets to them. Comments without a user_id from before that column
+-- existed still show who wrote them, the name goes with the user so the
+-- two can be told apart
+CREATE TRIGGER IF NOT EXISTS users_delete_comments_author BEFORE DELETE ON users BEGIN
+    UPDATE comments SET user_id = NULL, author = '[deleted]', updated_at = CURRENT_TIMESTAMP WHERE user_id = old.id;
+END;
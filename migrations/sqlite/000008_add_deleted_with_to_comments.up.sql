@@ -0,0 +1,5 @@
+-- the comment a soft delete was made on, shared by every reply deleted
+-- along with it. deleted_at is only kept to the second so it cannot
+-- tell two deletes in the same second apart. Comments deleted before
+-- this column existed fall back to deleted_at
+ALTER TABLE comments ADD COLUMN deleted_with INTEGER;
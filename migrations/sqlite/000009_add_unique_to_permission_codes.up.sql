@@ -0,0 +1,3 @@
+-- the code is how the API looks a permission up, so there must only be
+-- one row for each
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_key ON permissions(code);